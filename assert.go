@@ -0,0 +1,163 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Assertion is a threshold checked against the final report, eg.
+//
+//     p99<250ms
+//     success_rate>0.999
+//     rps>=900
+//
+// Latency metrics(min, mean, max, stddev, p50, p75, p90, p95, p99, p99.9) are compared with durations,
+// the others(rps, success_rate, error_rate, requests, failed_requests) with numbers.
+type Assertion struct {
+    expr   string
+    metric string
+    op     string
+    value  float64 // Seconds for latency metrics
+}
+
+// Result of an assertion on the report
+type AssertionResult struct {
+    Assertion string `json:"assertion"`
+    Actual    float64 `json:"actual"` // Seconds for latency metrics
+    Passed    bool `json:"passed"`
+    latency   bool
+}
+
+var assertionParser = regexp.MustCompile(`^\s*([a-z0-9_.]+)\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// Metrics of the report can be asserted, and whether they are latencies
+var assertionMetrics = map[string]bool{
+    "min": true, "mean": true, "max": true, "stddev": true,
+    "p50": true, "p75": true, "p90": true, "p95": true, "p99": true, "p99.9": true, "p999": true,
+    "rps": false, "success_rate": false, "error_rate": false, "requests": false, "failed_requests": false,
+}
+
+// Parse an assertion like p99<250ms, see Assertion
+func ParseAssertion(expr string) (*Assertion, error) {
+    m := assertionParser.FindStringSubmatch(expr)
+    if m == nil {
+        return nil, fmt.Errorf("not valid assertion: %s, expect like p99<250ms", expr)
+    }
+    a := &Assertion{expr: strings.TrimSpace(expr), metric: m[1], op: m[2]}
+    isLatency, ok := assertionMetrics[a.metric]
+    if !ok {
+        return nil, fmt.Errorf("unknown metric %s in assertion: %s", a.metric, expr)
+    }
+    if isLatency {
+        d, err := time.ParseDuration(m[3])
+        if err != nil {
+            return nil, fmt.Errorf("%s must be compared with a duration like 250ms: %s", a.metric, expr)
+        }
+        a.value = d.Seconds()
+    } else {
+        v, err := strconv.ParseFloat(m[3], 64)
+        if err != nil {
+            return nil, fmt.Errorf("%s must be compared with a number: %s", a.metric, expr)
+        }
+        a.value = v
+    }
+    return a, nil
+}
+
+// Parse all the assertions
+func ParseAssertions(exprs []string) ([]*Assertion, error) {
+    assertions := make([]*Assertion, 0, len(exprs))
+    for _, expr := range exprs {
+        a, err := ParseAssertion(expr)
+        if err != nil {
+            return nil, err
+        }
+        assertions = append(assertions, a)
+    }
+    return assertions, nil
+}
+
+// Check the assertion against the report
+func (a *Assertion) Check(r *Report) *AssertionResult {
+    actual := a.actual(r)
+    result := &AssertionResult{Assertion: a.expr, Actual: actual, latency: assertionMetrics[a.metric]}
+    switch a.op {
+    case "<":
+        result.Passed = actual < a.value
+    case "<=":
+        result.Passed = actual <= a.value
+    case ">":
+        result.Passed = actual > a.value
+    case ">=":
+        result.Passed = actual >= a.value
+    case "==":
+        result.Passed = actual == a.value
+    case "!=":
+        result.Passed = actual != a.value
+    }
+    return result
+}
+
+// The value of the metric in the report
+func (a *Assertion) actual(r *Report) float64 {
+    p := r.LatencyPercentiles
+    if p == nil {
+        p = &LatencyPercentiles{}
+    }
+    switch a.metric {
+    case "min":
+        return r.MinLatency
+    case "mean":
+        return r.MeanLatency
+    case "max":
+        return r.MaxLatency
+    case "stddev":
+        return r.LatencyStdDev
+    case "p50":
+        return p.P50
+    case "p75":
+        return p.P75
+    case "p90":
+        return p.P90
+    case "p95":
+        return p.P95
+    case "p99":
+        return p.P99
+    case "p99.9", "p999":
+        return p.P999
+    case "rps":
+        return r.RequestPerSecond
+    case "success_rate":
+        return r.SuccessRate
+    case "error_rate":
+        return 1 - r.SuccessRate
+    case "requests":
+        return float64(r.CompletedRequests)
+    case "failed_requests":
+        return float64(r.FailedRequests)
+    }
+    return 0
+}
+
+// Format the actual value of the result, latencies in milliseconds
+func (result *AssertionResult) actualString() string {
+    if result.latency {
+        return fmt.Sprintf("%.3fms", result.Actual * 1000)
+    }
+    return fmt.Sprintf("%.6g", result.Actual)
+}
+
+// Repeatable string flag, eg. -assert "p99<250ms" -assert "rps>=900"
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+    return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+    *s = append(*s, v)
+    return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestParseAssertion(t *testing.T) {
+    tests := []struct {
+        expr   string
+        metric string
+        op     string
+        value  float64
+        err    string
+    }{
+        {"p99<250ms", "p99", "<", 0.25, ""},
+        {" p99.9 <= 1s ", "p99.9", "<=", 1, ""},
+        {"success_rate>0.999", "success_rate", ">", 0.999, ""},
+        {"rps>=900", "rps", ">=", 900, ""},
+        {"failed_requests==0", "failed_requests", "==", 0, ""},
+        {"error_rate!=1", "error_rate", "!=", 1, ""},
+        {"p99", "", "", 0, "not valid assertion"},
+        {"p99=250ms", "", "", 0, "not valid assertion"},
+        {"latency<1s", "", "", 0, "unknown metric"},
+        {"p99<250", "", "", 0, "with a duration"},
+        {"rps>fast", "", "", 0, "with a number"},
+    }
+    for _, tt := range tests {
+        a, err := ParseAssertion(tt.expr)
+        if tt.err != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("ParseAssertion(%q): error = %v, want containing %q", tt.expr, err, tt.err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("ParseAssertion(%q): %s", tt.expr, err)
+            continue
+        }
+        if a.metric != tt.metric || a.op != tt.op || a.value != tt.value {
+            t.Errorf("ParseAssertion(%q) = %s %s %v, want %s %s %v", tt.expr, a.metric, a.op, a.value,
+                tt.metric, tt.op, tt.value)
+        }
+    }
+}
+
+func TestAssertionCheck(t *testing.T) {
+    report := &Report{
+        CompletedRequests: 1000,
+        FailedRequests: 2,
+        SuccessRate: 0.998,
+        RequestPerSecond: 950,
+        MinLatency: 0.001,
+        MeanLatency: 0.02,
+        MaxLatency: 0.5,
+        LatencyPercentiles: &LatencyPercentiles{P50: 0.015, P90: 0.1, P99: 0.2, P999: 0.4},
+    }
+    tests := []struct {
+        expr   string
+        passed bool
+        actual string
+    }{
+        {"p99<250ms", true, "200.000ms"},
+        {"p99<200ms", false, "200.000ms"},
+        {"p99<=200ms", true, "200.000ms"},
+        {"p99.9>300ms", true, "400.000ms"},
+        {"p999>=1s", false, "400.000ms"},
+        {"max<=500ms", true, "500.000ms"},
+        {"success_rate>0.999", false, "0.998"},
+        {"error_rate<0.01", true, "0.002"},
+        {"rps>=900", true, "950"},
+        {"requests==1000", true, "1000"},
+        {"failed_requests!=0", true, "2"},
+    }
+    for _, tt := range tests {
+        a, err := ParseAssertion(tt.expr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        result := a.Check(report)
+        if result.Passed != tt.passed || result.actualString() != tt.actual {
+            t.Errorf("%s: passed %v, actual %s, want %v, %s", tt.expr, result.Passed, result.actualString(),
+                tt.passed, tt.actual)
+        }
+    }
+}
+
+// Latency assertions on a report without percentiles compare with zero
+func TestAssertionWithoutPercentiles(t *testing.T) {
+    a, _ := ParseAssertion("p99<1ms")
+    if result := a.Check(&Report{}); !result.Passed || result.Actual != 0 {
+        t.Errorf("p99<1ms on an empty report = %+v", result)
+    }
+}
+
+func TestParseAssertions(t *testing.T) {
+    var flags stringsFlag
+    flags.Set("p99<250ms")
+    flags.Set("rps>=900")
+    if flags.String() != "p99<250ms, rps>=900" {
+        t.Errorf("flag = %s", flags.String())
+    }
+    assertions, err := ParseAssertions(flags)
+    if err != nil || len(assertions) != 2 {
+        t.Errorf("ParseAssertions(%v) = %d, %v", flags, len(assertions), err)
+    }
+    if _, err := ParseAssertions([]string{"p99<250ms", "bogus"}); err == nil {
+        t.Error("ParseAssertions with a bad one: no error")
+    }
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+    "fmt"
+    "net"
+    "regexp"
     "time"
     "log"
     "strings"
     "os"
-    "bufio"
     "os/signal"
 )
 
@@ -15,10 +17,10 @@ type BoomOptions struct {
     // The username and password are separated by a single : .
     Authentication             string
 
-    // -a: Local address
+    // -la: Local addresses to bind to, separated by commas. Connections rotate across them.
     LocalAddr                  string
 
-    // -C: Add a Cookie: line to the request like: cookie-name=value
+    // -C: Add a Cookie: line to the request like: cookie-name=value. Separate multiple cookies by ;
     RequestCookies             string
 
     // -c: Content-type header to use for POST/PUT data,
@@ -28,18 +30,78 @@ type BoomOptions struct {
     // -D: File or just a string containing data to POST. Remember to also set -c.
     RequestPostData            string
 
+    // -stream-body: Read the body file from disk for every request instead of holding it in memory.
+    StreamBody                 bool
+
     // -g: Number of threads(goroutines) to perform for the test.
     RequestGoroutines          int
 
     // -H: Append extra headers to the request like: head-type:value
     RequestHeaders             string
 
+    // -http2: Send requests over HTTP/2, negotiated over TLS or with prior knowledge(h2c) over cleartext.
+    Http2Enable                bool
+
+    // -http3: Send requests over HTTP/3(QUIC), boom must be built with the http3 tag.
+    Http3Enable                bool
+
+    // -0rtt: With -http3, send GET and HEAD requests in 0-RTT data when QUIC connections are resumed.
+    Allow0RTT                  bool
+
+    // -grpc: Call unary gRPC methods, URLs are like grpc://host:port/package.Service/Method. Built with the grpc tag.
+    GRPCEnable                 bool
+
+    // -grpc-protoset: Descriptor set of the gRPC methods, server reflection is used if it's empty.
+    GRPCProtoset               string
+
+    // -ws: Send the body as WebSocket messages over -g connections, URLs are like ws://host/path.
+    WebSocketEnable            bool
+
+    // -ws-correlate: JSON path of the correlation id matching the replies of the messages, the echo if it's empty.
+    WSCorrelate                string
+
+    // -socket: Send the body as a payload over raw TCP or UDP, URLs are like tcp://host:port or udp://host:port.
+    SocketEnable               bool
+
+    // -socket-hex: With -socket, the body is hex.
+    SocketHex                  bool
+
+    // -socket-conns: With -socket, persistent TCP connections the requests are pipelined on, 0 for a connection each request.
+    SocketConns                int
+
+    // -socket-read-timeout: With -socket, how long to wait for a response, -s if it's 0.
+    SocketReadTimeout          time.Duration
+
+    // -socket-no-reply: With -socket, don't wait for responses.
+    SocketNoReply              bool
+
+    // -max-conns: Most connections to each host, 0 for no limit.
+    MaxConnsPerHost            int
+
+    // -h2-strict-streams: Don't open more HTTP/2 connections when the streams reach the server's limit.
+    StrictMaxStreams           bool
+
     // -k: Enable the HTTP KeepAlive feature
     EnableKeepAlive            bool
 
     // -m: Custom HTTP method for the requests.
     RequestMethod              string
 
+    // -closed: Closed loop, -g virtual users each sends a request, waits for the response and thinks, then again.
+    ClosedLoop                 bool
+
+    // -think: Think time of virtual users in closed loop, eg. 500ms, uniform:100ms-1s or exp:500ms
+    ThinkTime                  string
+
+    // -iterations: Requests each virtual user sends in closed loop, for -t if it's 0.
+    Iterations                 int
+
+    // -max-workers: Most warheads in open loop, no limit if it's 0.
+    MaxWorkers                 int
+
+    // -overflow: What to do with a tick in open loop when -max-workers warheads are busy, drop or queue.
+    Overflow                   string
+
     // -n: Number of requests to perform for the test. If this flag > 0, the -t and -r will be ignore.
     TotalRequests              int
 
@@ -48,56 +110,217 @@ type BoomOptions struct {
 
     // -u： The url to request
     URL                        string
+
+    // -targets: File of targets in vegeta format, used instead of -u.
+    TargetsFile                string
+
+    // -targets-order: Order to hit the targets, round-robin or random.
+    TargetsOrder               string
+
+    // -templates: Render the url, header values and body of targets as templates for every request.
+    Templates                  bool
+
+    // -feeder-mode: How the rows of CSV files used by templates are picked, sequential, circular or random.
+    FeederMode                 string
+
     // -o: Output the reports in specified location
     ResultOutput               string
 
+    // -interval: Interval of the rows in the time series of the report.
+    TimeSeriesInterval         time.Duration
+
+    // -timeseries: Output the time series of the report in specified CSV file.
+    TimeSeriesOutput           string
+
+    // -format: Format of the report file, json, csv or text. Picked by the extension of -o if empty.
+    ResultFormat               string
+
+    // -success-codes: Status codes of successful responses, eg. 200-299,302
+    SuccessCodes               string
+
+    // -expect-header: Header required in successful responses, like: name or name:value
+    ExpectHeader               string
+
+    // -expect-body: Text successful response bodies must contain.
+    ExpectBody                 string
+
+    // -expect-body-regex: Regex successful response bodies must match.
+    ExpectBodyRegex            string
+
+    // -expect-json: Assertion on successful JSON response bodies, eg. $.status == "ok"
+    ExpectJSON                 string
+
+    // -assert: Thresholds checked against the final report, eg. p99<250ms. Boom fails if any is not met.
+    Assertions                 stringsFlag
+
+    // -progress: Show the live status on stderr during the attack.
+    ShowProgress               bool
+
+    // -dump: Stream every damage to the file in JSON Lines format.
+    DumpFile                   string
+
+    // -raw: Keep every damage in memory, so the latencies are exact and the JSON report holds all the damages.
+    RawSamples                 bool
+
     // -r: Number of requests to perform at one sec.
     RequestPerSec              int
 
-    // -s: Maximum number of seconds to wait before a request times out.
+    // -find-max: Search the highest rate meeting the criteria, which are assertions separated by commas.
+    FindMax                    string
+
+    // -profile: Shape of the rate instead of the constant -r, eg. ramp:10-500 or @stages.txt
+    LoadProfile                string
+
+    // -s: How long to wait for a response, the response headers over HTTP, before a request times out.
     RequestTimeout             time.Duration
 }
 
-func Boom(opts *BoomOptions) {
+// Run the test, returns errAssertionsFailed if any assertion of -assert failed.
+// With -find-max, the capacity is searched instead, returns errCapacityNotFound if no rate meets the criteria.
+func Boom(opts *BoomOptions) error {
     err := checkOpts(opts)
     if err != nil {
-        log.Fatal(err.Error())
+        exitWithError("%s", err)
     }
-    target := createTarget(opts)
+    assertions, err := ParseAssertions(opts.Assertions)
+    if err != nil {
+        exitWithError("%s", err)
+    }
+    var criteria []*Assertion
+    if opts.FindMax != "" {
+        if criteria, err = ParseAssertions(strings.Split(opts.FindMax, ",")); err != nil {
+            exitWithError("%s", err)
+        }
+    }
+    think, err := ParseThinkTime(opts.ThinkTime)
+    if err != nil {
+        exitWithError("%s", err)
+    }
+    var profile LoadProfile
+    if opts.openLoop() {
+        if profile, err = ParseLoadProfile(opts.LoadProfile, opts.RequestPerSec, opts.RequestDuration); err != nil {
+            exitWithError("%s", err)
+        }
+    }
+    targets := createTargets(opts)
     log.Println("Target ready.")
 
-    missile := createMissile(opts)
+    missile := createMissile(opts, targets)
     log.Println("Missile ready.")
 
+    var dumper *Dumper
+    if opts.DumpFile != "" {
+        dumper, err = NewDumper(opts.DumpFile)
+        if err != nil {
+            exitWithError("Can't create dump file %s: %s", opts.DumpFile, err)
+        }
+        defer func() {
+            if err := dumper.Close(); err != nil {
+                fmt.Fprintf(os.Stderr, "Dump damages to %s error: %s\n", opts.DumpFile, err)
+            }
+        }()
+    }
+
+    if opts.FindMax != "" {
+        return findMax(opts, criteria, targets, missile, dumper)
+    }
+
+    collector := NewCollector(opts.RawSamples, targets, opts.TimeSeriesInterval, profile)
+    attack(opts, missile, targets, profile, think, collector, dumper, opts.ShowProgress)
+    report := createReport(opts, collector, targets, assertions)
+    return checkAssertions(report, assertions)
+}
 
+// Launch the missile and collect the damages until all of them are received, or CTRL+C is pressed.
+// The damages are dumped too if dumper is not nil. Returns false if it's stopped by CTRL+C.
+func attack(opts *BoomOptions, missile *Missile, targets *Targets, profile LoadProfile, think *ThinkTime,
+    collector *Collector, dumper *Dumper, showProgress bool) bool {
 
-    damagesResult := missile.Launch(target, opts.TotalRequests, opts.RequestPerSec, opts.RequestDuration)
+    var damagesResult <-chan *Damage
+    if opts.ClosedLoop {
+        damagesResult = missile.LaunchUsers(targets, opts.Iterations, opts.RequestDuration, think)
+    } else {
+        damagesResult = missile.Launch(targets, opts.TotalRequests, profile)
+    }
 
     log.Println("The missile launched!")
 
+    var (
+        progress *Progress
+        progressTick <-chan time.Time
+    )
+    if showProgress {
+        progress = NewProgress(os.Stderr, opts, missile, profile)
+        ticker := time.NewTicker(progressInterval)
+        defer ticker.Stop()
+        progressTick = ticker.C
+    }
+
     killFlag := make(chan os.Signal, 1)
     signal.Notify(killFlag, os.Interrupt)
+    defer signal.Stop(killFlag)
 
+    completed := true
+loop:
     for {
         select {
         case <-killFlag:
             missile.Stop()
             log.Println("Press CTRL+C")
-            createReport(opts)
-            return
+            completed = false
+            break loop
+        case now := <-progressTick:
+            progress.render(now)
         case r, ok := <-damagesResult:
             if !ok {
-                createReport(opts)
-                return
+                break loop
             } else {
-                collectDamage(r)
+                collector.collectDamage(r)
+                if progress != nil {
+                    progress.add(r)
+                }
+                if dumper != nil {
+                    dumper.dump(r)
+                }
             }
         }
     }
+    if progress != nil {
+        progress.finish()
+    }
+    collector.collectOverflow(missile.OverflowTicks())
+    return completed
+}
 
+// Whether requests are sent at the rate of -r or -profile, instead of -n or closed loop
+func (opts *BoomOptions) openLoop() bool {
+    return opts.TotalRequests <= 0 && !opts.ClosedLoop
 }
 
-func createMissile(opts *BoomOptions) *Missile {
+// Print the failed assertions to stderr, returns errAssertionsFailed if there is any.
+func checkAssertions(report *Report, assertions []*Assertion) error {
+    if len(assertions) == 0 {
+        return nil
+    }
+    if report == nil {
+        fmt.Fprintln(os.Stderr, "No requests completed, all assertions failed.")
+        return errAssertionsFailed
+    }
+    failed := 0
+    for _, result := range report.Assertions {
+        if !result.Passed {
+            failed++
+            fmt.Fprintf(os.Stderr, "Assertion failed: %s (actual: %s)\n", result.Assertion, result.actualString())
+        }
+    }
+    if failed > 0 {
+        return errAssertionsFailed
+    }
+    return nil
+}
+
+// Create the missile, the engine of protocols other than HTTP is prepared for the targets.
+func createMissile(opts *BoomOptions, targets *Targets) *Missile {
 
     cc := NewDefaultCtrlCenter()
     if opts.RequestTimeout > 0 {
@@ -110,10 +333,93 @@ func createMissile(opts *BoomOptions) *Missile {
     } else {
         cc.Warheads = defaultWarheads
     }
+    cc.MaxWorkers = opts.MaxWorkers
+    cc.Http2Enable = opts.Http2Enable
+    cc.Http3Enable = opts.Http3Enable
+    cc.Allow0RTT = opts.Allow0RTT
+    cc.MaxConnsPerHost = opts.MaxConnsPerHost
+    cc.StrictMaxStreams = opts.StrictMaxStreams
+    if opts.Overflow != "" {
+        cc.Overflow = opts.Overflow
+    }
     if opts.EnableKeepAlive {
         cc.KeepAlive = 30 * time.Second
     }
-    return NewCustomMissile(cc)
+    cc.Expect = createExpectation(opts)
+    if cc.Expect.acceptsRedirects() {
+        // The redirect is checked as the response instead of the response it leads to
+        cc.MaxRedirects = noFollow
+    }
+    if opts.LocalAddr != "" {
+        addrs, err := parseLocalAddrs(opts.LocalAddr)
+        if err != nil {
+            exitWithError("%s", err)
+        }
+        cc.LocalAddrs = addrs
+    }
+    missile := NewCustomMissile(cc)
+    var (
+        engine Engine
+        err    error
+    )
+    switch {
+    case opts.GRPCEnable:
+        engine, err = newGRPCEngine(cc, missile.dial, opts.GRPCProtoset, targets)
+    case opts.WebSocketEnable:
+        engine, err = newWSEngine(cc, missile.dial, opts.WSCorrelate, targets)
+    case opts.SocketEnable:
+        engine, err = newSocketEngine(cc, missile.dial, SocketOptions{
+            Hex: opts.SocketHex,
+            Conns: opts.SocketConns,
+            ReadTimeout: opts.SocketReadTimeout,
+            NoReply: opts.SocketNoReply,
+        })
+    }
+    if err != nil {
+        exitWithError("%s", err)
+    }
+    if engine != nil {
+        missile.Arm(engine)
+    }
+    return missile
+}
+
+// Create the targets from the targets file, or the single target specified by -u
+func createTargets(opts *BoomOptions) *Targets {
+    var list []*Target
+    if opts.TargetsFile != "" {
+        var err error
+        list, err = ReadTargets(opts.TargetsFile, opts.StreamBody)
+        if err != nil {
+            exitWithError("Read targets from %s error: %s", opts.TargetsFile, err)
+        }
+        for _, target := range list {
+            addHeaders(target, opts.RequestHeaders)
+        }
+    } else {
+        list = []*Target{createTarget(opts)}
+    }
+    for _, target := range list {
+        if err := setCredentials(target, opts); err != nil {
+            exitWithError("%s", err)
+        }
+    }
+    if opts.Templates {
+        feeders, err := NewFeeders(opts.FeederMode)
+        if err != nil {
+            exitWithError("%s", err)
+        }
+        for _, target := range list {
+            if err := target.Compile(feeders); err != nil {
+                exitWithError("Compile templates of %s error: %s", target.Name(), err)
+            }
+        }
+    }
+    targets, err := NewTargets(list, opts.TargetsOrder)
+    if err != nil {
+        exitWithError("%s", err)
+    }
+    return targets
 }
 
 func createTarget(opts *BoomOptions) *Target {
@@ -121,35 +427,19 @@ func createTarget(opts *BoomOptions) *Target {
 
     target.SetMethod(opts.RequestMethod)
 
-    if opts.RequestHeaders != "" {
-        // header
-        headerTokens := strings.Split(opts.RequestHeaders, ";")
-        for _, sh := range headerTokens {
-            headerValue := strings.Split(sh, ":")
-            if len(headerValue) != 2 {
-                log.Fatalf("Not valid http header:%s", sh)
-            }
-            target.AddHeader(strings.TrimSpace(headerValue[0]), strings.TrimSpace(headerValue[1]))
-        }
-    }
+    addHeaders(target, opts.RequestHeaders)
 
     // post data
     if opts.RequestPostData != "" {
         body := opts.RequestPostData
         if strings.HasPrefix(body, "@@") {
             bodyContentFile := strings.TrimPrefix(body, "@@")
-            f, err := os.Open(bodyContentFile)
-            if err != nil {
-                log.Fatalf("Can't open file specified in :%s", bodyContentFile)
-            }
-            reader := bufio.NewReader(f)
-            bodyBytes := []byte{}
-            n, err := reader.Read(bodyBytes)
-            if n > 0 && err == nil {
-                target.Body = bodyBytes
-            } else {
-                log.Fatalf("Read file to post error :%s", bodyContentFile)
+            if err := target.SetBodyFile(bodyContentFile, opts.StreamBody); err != nil {
+                exitWithError("Read file to post error :%s", err)
             }
+        } else if opts.SocketEnable {
+            // Payloads are sent as they are, eg. ending with \r\n
+            target.Body = []byte(body)
         } else {
             target.Body = []byte(strings.TrimSpace(body))
         }
@@ -163,13 +453,146 @@ func createTarget(opts *BoomOptions) *Target {
     return target
 }
 
+// Create the rules of a successful response
+func createExpectation(opts *BoomOptions) *Expectation {
+    expect := NewExpectation()
+    if opts.SuccessCodes != "" {
+        ranges, err := ParseStatusRanges(opts.SuccessCodes)
+        if err != nil {
+            exitWithError("%s", err)
+        }
+        expect.StatusRanges = ranges
+    }
+    if opts.ExpectHeader != "" {
+        nameValue := strings.SplitN(opts.ExpectHeader, ":", 2)
+        expect.Header = strings.TrimSpace(nameValue[0])
+        if len(nameValue) == 2 {
+            expect.HeaderValue = strings.TrimSpace(nameValue[1])
+        }
+    }
+    if opts.ExpectBodyRegex != "" {
+        re, err := regexp.Compile(opts.ExpectBodyRegex)
+        if err != nil {
+            exitWithError("Not valid body regex:%s", err)
+        }
+        expect.BodyRegex = re
+    }
+    expect.BodyContains = opts.ExpectBody
+    if opts.ExpectJSON != "" {
+        a, err := ParseJSONAssertion(opts.ExpectJSON)
+        if err != nil {
+            exitWithError("%s", err)
+        }
+        expect.JSON = a
+    }
+    return expect
+}
+
+// Set the BASIC Authentication(-A) and cookies(-C) of the target
+func setCredentials(target *Target, opts *BoomOptions) error {
+    if opts.Authentication != "" {
+        userPass := strings.SplitN(opts.Authentication, ":", 2)
+        if len(userPass) != 2 {
+            return errAuthentication
+        }
+        target.SetBasicAuth(userPass[0], userPass[1])
+    }
+    if opts.RequestCookies != "" {
+        for _, c := range strings.Split(opts.RequestCookies, ";") {
+            if c = strings.TrimSpace(c); c == "" {
+                continue
+            }
+            nameValue := strings.SplitN(c, "=", 2)
+            if len(nameValue) != 2 {
+                return fmt.Errorf("Not valid cookie:%s", c)
+            }
+            target.AddCookie(strings.TrimSpace(nameValue[0]), strings.TrimSpace(nameValue[1]))
+        }
+    }
+    return nil
+}
+
+// Parse local addresses like: 10.0.0.1,10.0.0.2
+func parseLocalAddrs(addrs string) ([]*net.IPAddr, error) {
+    ipAddrs := make([]*net.IPAddr, 0)
+    for _, addr := range strings.Split(addrs, ",") {
+        if addr = strings.TrimSpace(addr); addr == "" {
+            continue
+        }
+        ip := net.ParseIP(addr)
+        if ip == nil {
+            return nil, fmt.Errorf("Not valid local address:%s", addr)
+        }
+        ipAddrs = append(ipAddrs, &net.IPAddr{IP: ip})
+    }
+    return ipAddrs, nil
+}
+
+// Add headers like: head-type:value;head-type:value
+func addHeaders(target *Target, headers string) {
+    if headers == "" {
+        return
+    }
+    headerTokens := strings.Split(headers, ";")
+    for _, sh := range headerTokens {
+        headerValue := strings.SplitN(sh, ":", 2)
+        if len(headerValue) != 2 {
+            log.Fatalf("Not valid http header:%s", sh)
+        }
+        target.AddHeader(strings.TrimSpace(headerValue[0]), strings.TrimSpace(headerValue[1]))
+    }
+}
+
+// Print the error message to stderr then exit, no matter the log output is enabled or not.
+func exitWithError(format string, args ...interface{}) {
+    fmt.Fprintf(os.Stderr, format + "\n", args...)
+    os.Exit(1)
+}
+
 func checkOpts(opts *BoomOptions) error {
     if opts == nil {
         return errNilBoomOpts
     }
-    if opts.URL == "" {
+    if opts.URL == "" && opts.TargetsFile == "" {
         return errBoomOpts
     }
+    if opts.openLoop() && opts.LoadProfile == "" && opts.RequestPerSec <= 0 {
+        return errZeroRate
+    }
+    if opts.FindMax != "" && (opts.TotalRequests > 0 || opts.LoadProfile != "" || opts.ClosedLoop) {
+        return errFindMaxMode
+    }
+    if opts.ClosedLoop && (opts.TotalRequests > 0 || opts.LoadProfile != "") {
+        return errClosedLoopMode
+    }
+    if opts.Http3Enable && !http3Supported {
+        return errNoHTTP3
+    }
+    if opts.Http3Enable && opts.Http2Enable {
+        return errHTTPVersion
+    }
+    if opts.GRPCEnable && !grpcSupported {
+        return errNoGRPC
+    }
+    engines := 0
+    for _, enabled := range []bool{opts.GRPCEnable, opts.WebSocketEnable, opts.SocketEnable} {
+        if enabled {
+            engines++
+        }
+    }
+    if engines > 1 {
+        return errEngineMode
+    }
+    switch opts.Overflow {
+    case "", overflowDrop, overflowQueue:
+    default:
+        return errOverflow
+    }
+    switch opts.ResultFormat {
+    case "", formatJSON, formatCSV, formatText:
+    default:
+        return errReportFormat
+    }
     // Some other check
     return nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestSetCredentials(t *testing.T) {
+    tests := []struct {
+        name    string
+        auth    string
+        cookies string
+        user    string
+        pass    string
+        cookie  string
+        err     bool
+    }{
+        {"none", "", "", "", "", "", false},
+        {"basic auth", "ann:s3cret", "", "ann", "s3cret", "", false},
+        {"colon in password", "ann:a:b", "", "ann", "a:b", "", false},
+        {"auth without password", "ann", "", "", "", "", true},
+        {"cookies", "", "session=abc; theme = dark ;", "", "", "session=abc; theme=dark", false},
+        {"cookie without value", "", "session", "", "", "", true},
+    }
+    for _, tt := range tests {
+        target := NewTarget("http://localhost/")
+        err := setCredentials(target, &BoomOptions{Authentication: tt.auth, RequestCookies: tt.cookies})
+        if (err != nil) != tt.err {
+            t.Errorf("%s: error = %v", tt.name, err)
+            continue
+        }
+        if tt.err {
+            continue
+        }
+        req, err := target.Request()
+        if err != nil {
+            t.Fatal(err)
+        }
+        user, pass, ok := req.BasicAuth()
+        if ok != (tt.user != "") || user != tt.user || pass != tt.pass {
+            t.Errorf("%s: basic auth = %q:%q(%v), want %q:%q", tt.name, user, pass, ok, tt.user, tt.pass)
+        }
+        if got := req.Header.Get("Cookie"); got != tt.cookie {
+            t.Errorf("%s: cookie = %q, want %q", tt.name, got, tt.cookie)
+        }
+    }
+}
+
+func TestParseLocalAddrs(t *testing.T) {
+    tests := []struct {
+        addrs string
+        want  []string
+        err   bool
+    }{
+        {"10.0.0.1", []string{"10.0.0.1"}, false},
+        {"10.0.0.1, 10.0.0.2,", []string{"10.0.0.1", "10.0.0.2"}, false},
+        {"::1,127.0.0.1", []string{"::1", "127.0.0.1"}, false},
+        {"10.0.0.1,localhost", nil, true},
+        {"10.0.0.256", nil, true},
+    }
+    for _, tt := range tests {
+        addrs, err := parseLocalAddrs(tt.addrs)
+        if (err != nil) != tt.err {
+            t.Errorf("%q: error = %v", tt.addrs, err)
+            continue
+        }
+        if len(addrs) != len(tt.want) {
+            t.Errorf("%q: %d addresses, want %d", tt.addrs, len(addrs), len(tt.want))
+            continue
+        }
+        for i, addr := range addrs {
+            if addr.String() != tt.want[i] {
+                t.Errorf("%q: address %d = %s, want %s", tt.addrs, i, addr, tt.want[i])
+            }
+        }
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    capacityPrecision = 0.05    // The search stops when the failed rate is within 5% above the passed one
+    maxCapacityProbes = 30
+    maxCapacityRate = 1000000
+)
+
+// CapacityReport is the result of -find-max: the highest rate meeting the criteria, and every rate probed.
+type CapacityReport struct {
+    Criteria      []string `json:"criteria"`
+    StageDuration float64 `json:"stage_duration"` // Seconds each rate is probed
+    MaxRate       float64 `json:"max_rate"`       // Highest rate passed, 0 if none
+    Probes        []*CapacityProbe `json:"probes"` // In the order probed
+}
+
+// Stats of a probed rate
+type CapacityProbe struct {
+    Rate              float64 `json:"rate"`
+    RequestPerSecond  float64 `json:"request_per_second"` // Achieved rate
+    CompletedRequests int `json:"completed_requests"`
+    FailedRequests    int `json:"failed_requests"`
+    ErrorRate         float64 `json:"error_rate"`
+    MeanLatency       float64 `json:"mean_latency"`
+    P50               float64 `json:"p50"`
+    P99               float64 `json:"p99"`
+    MaxLatency        float64 `json:"max_latency"`
+    Passed            bool `json:"passed"`
+    Failures          []string `json:"failures,omitempty"` // Criteria not met
+}
+
+// Search the highest rate meeting all the criteria. Each rate is probed for -t, starting from -r.
+// The rate is doubled until a probe fails, then binary searched between the last passed and the first failed.
+// Returns errCapacityNotFound if no rate passed.
+func findMax(opts *BoomOptions, criteria []*Assertion, targets *Targets, missile *Missile, dumper *Dumper) error {
+    capacity := &CapacityReport{StageDuration: opts.RequestDuration.Seconds()}
+    for _, c := range criteria {
+        capacity.Criteria = append(capacity.Criteria, c.expr)
+    }
+    fmt.Printf("Searching the max rate with %s, %s each rate...\n",
+        strings.Join(capacity.Criteria, ", "), formatElapsed(opts.RequestDuration))
+
+    rate := math.Max(1, float64(opts.RequestPerSec))
+    passed, failed := float64(0), float64(0)
+    for len(capacity.Probes) < maxCapacityProbes {
+        probe, ok := probeRate(opts, criteria, targets, missile, dumper, rate)
+        if !ok {
+            break
+        }
+        capacity.Probes = append(capacity.Probes, probe)
+        printProbe(os.Stdout, len(capacity.Probes), probe)
+        if probe.Passed {
+            passed = rate
+            capacity.MaxRate = rate
+        } else {
+            failed = rate
+        }
+
+        if failed == 0 {
+            if rate >= maxCapacityRate {
+                break
+            }
+            rate *= 2
+            continue
+        }
+        next := math.Floor((passed + failed) / 2)
+        if failed - passed <= math.Max(passed * capacityPrecision, 1) || next <= passed {
+            break
+        }
+        rate = next
+    }
+
+    if opts.ResultOutput != "Stdout" {
+        if err := writeReportFile(opts.ResultOutput, opts.ResultFormat, capacity, capacity.prettyPrint); err != nil {
+            fmt.Fprintf(os.Stderr, "Write report to %s error: %s\n", opts.ResultOutput, err)
+            capacity.prettyPrint(os.Stdout)
+        }
+    } else {
+        capacity.prettyPrint(os.Stdout)
+    }
+    if capacity.MaxRate == 0 {
+        return errCapacityNotFound
+    }
+    return nil
+}
+
+// Attack at the rate for -t and check the criteria, returns false if it's stopped by CTRL+C.
+func probeRate(opts *BoomOptions, criteria []*Assertion, targets *Targets, missile *Missile, dumper *Dumper,
+    rate float64) (*CapacityProbe, bool) {
+
+    profile := &constantProfile{rate, opts.RequestDuration}
+    collector := NewCollector(false, targets, 0, profile)
+    if !attack(opts, missile, targets, profile, nil, collector, dumper, false) {
+        return nil, false
+    }
+    probe := &CapacityProbe{Rate: rate}
+    report := newReport(opts, collector, targets, criteria)
+    if report == nil {
+        probe.Failures = []string{"no requests completed"}
+        return probe, true
+    }
+    probe.RequestPerSecond = report.RequestPerSecond
+    probe.CompletedRequests = report.CompletedRequests
+    probe.FailedRequests = report.FailedRequests
+    probe.ErrorRate = 1 - report.SuccessRate
+    probe.MeanLatency = report.MeanLatency
+    probe.P50 = report.LatencyPercentiles.P50
+    probe.P99 = report.LatencyPercentiles.P99
+    probe.MaxLatency = report.MaxLatency
+    probe.Passed = true
+    for _, result := range report.Assertions {
+        if !result.Passed {
+            probe.Passed = false
+            probe.Failures = append(probe.Failures, fmt.Sprintf("%s (actual: %s)", result.Assertion, result.actualString()))
+        }
+    }
+    return probe, true
+}
+
+// Print a line of the probe, as the search goes
+func printProbe(w io.Writer, n int, p *CapacityProbe) {
+    result := "PASS"
+    if !p.Passed {
+        result = "FAIL " + strings.Join(p.Failures, ", ")
+    }
+    fmt.Fprintf(w, "  #%d %.0f rps: achieved %.1f rps, p50 %.3fms, p99 %.3fms, errors %.2f %% [%s]\n",
+        n, p.Rate, p.RequestPerSecond, p.P50 * 1000, p.P99 * 1000, p.ErrorRate * 100, result)
+}
+
+// Print the capacity report as human readable text
+func (r *CapacityReport) prettyPrint(w io.Writer) {
+    fmt.Fprintf(w, "\nCapacity search(%s, %s each rate):\n",
+        strings.Join(r.Criteria, ", "), formatElapsed(time.Duration(r.StageDuration * float64(time.Second))))
+    fmt.Fprintf(w, "  %10s %10s %11s %11s %11s %11s %9s  %s\n",
+        "rate", "achieved", "mean", "p50", "p99", "max", "errors", "result")
+    for _, p := range sortedProbes(r.Probes) {
+        result := "PASS"
+        if !p.Passed {
+            result = "FAIL"
+        }
+        fmt.Fprintf(w, "  %10.0f %10.1f %9.3fms %9.3fms %9.3fms %9.3fms %7.2f %%  %s\n", p.Rate, p.RequestPerSecond,
+            p.MeanLatency * 1000, p.P50 * 1000, p.P99 * 1000, p.MaxLatency * 1000, p.ErrorRate * 100, result)
+    }
+    if r.MaxRate > 0 {
+        fmt.Fprintf(w, "Max rate: %.0f requests per second\n", r.MaxRate)
+    } else {
+        fmt.Fprintf(w, "Max rate: none of the rates probed meets the criteria\n")
+    }
+}
+
+// The probes in ascending order of rates
+func sortedProbes(probes []*CapacityProbe) []*CapacityProbe {
+    sorted := make([]*CapacityProbe, len(probes))
+    copy(sorted, probes)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i].Rate < sorted[j].Rate
+    })
+    return sorted
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+)
+
+func TestPrintProbe(t *testing.T) {
+    tests := []struct {
+        probe *CapacityProbe
+        want  string
+    }{
+        {&CapacityProbe{Rate: 400, RequestPerSecond: 399.5, P50: 0.0012, P99: 0.0085, Passed: true},
+            "  #2 400 rps: achieved 399.5 rps, p50 1.200ms, p99 8.500ms, errors 0.00 % [PASS]\n"},
+        {&CapacityProbe{Rate: 800, RequestPerSecond: 610, P50: 0.02, P99: 0.25, ErrorRate: 0.015,
+            Failures: []string{"p99<100ms (actual: 250ms)", "error-rate<1% (actual: 1.50%)"}},
+            "  #2 800 rps: achieved 610.0 rps, p50 20.000ms, p99 250.000ms, errors 1.50 % " +
+            "[FAIL p99<100ms (actual: 250ms), error-rate<1% (actual: 1.50%)]\n"},
+    }
+    for _, tt := range tests {
+        var buf bytes.Buffer
+        printProbe(&buf, 2, tt.probe)
+        if buf.String() != tt.want {
+            t.Errorf("printProbe = %q, want %q", buf.String(), tt.want)
+        }
+    }
+}
+
+func TestSortedProbes(t *testing.T) {
+    probes := []*CapacityProbe{{Rate: 100}, {Rate: 200}, {Rate: 400}, {Rate: 300}, {Rate: 250}}
+    sorted := sortedProbes(probes)
+    for i, want := range []float64{100, 200, 250, 300, 400} {
+        if sorted[i].Rate != want {
+            t.Errorf("probe %d has rate %.0f, want %.0f", i, sorted[i].Rate, want)
+        }
+    }
+    // The probes stay in the order probed
+    if probes[2].Rate != 400 {
+        t.Error("the probes are sorted in place")
+    }
+}
+
+func TestCapacityReportPrettyPrint(t *testing.T) {
+    tests := []struct {
+        report *CapacityReport
+        lines  []string
+    }{
+        {&CapacityReport{Criteria: []string{"p99<100ms"}, StageDuration: 10, MaxRate: 150, Probes: []*CapacityProbe{
+            {Rate: 100, RequestPerSecond: 100, P99: 0.05, Passed: true},
+            {Rate: 200, RequestPerSecond: 190, P99: 0.2},
+            {Rate: 150, RequestPerSecond: 150, P99: 0.08, Passed: true},
+        }}, []string{
+            "",
+            "Capacity search(p99<100ms, 10s each rate):",
+            "        rate   achieved        mean         p50         p99         max    errors  result",
+            "         100      100.0     0.000ms     0.000ms    50.000ms     0.000ms    0.00 %  PASS",
+            "         150      150.0     0.000ms     0.000ms    80.000ms     0.000ms    0.00 %  PASS",
+            "         200      190.0     0.000ms     0.000ms   200.000ms     0.000ms    0.00 %  FAIL",
+            "Max rate: 150 requests per second",
+        }},
+        {&CapacityReport{Criteria: []string{"error-rate<1%", "p50<1ms"}, StageDuration: 5, Probes: []*CapacityProbe{
+            {Rate: 10, RequestPerSecond: 10, ErrorRate: 0.5},
+        }}, []string{
+            "",
+            "Capacity search(error-rate<1%, p50<1ms, 5s each rate):",
+            "        rate   achieved        mean         p50         p99         max    errors  result",
+            "          10       10.0     0.000ms     0.000ms     0.000ms     0.000ms   50.00 %  FAIL",
+            "Max rate: none of the rates probed meets the criteria",
+        }},
+    }
+    for _, tt := range tests {
+        var buf bytes.Buffer
+        tt.report.prettyPrint(&buf)
+        want := strings.Join(tt.lines, "\n") + "\n"
+        if buf.String() != want {
+            t.Errorf("prettyPrint =\n%s\nwant\n%s", buf.String(), want)
+        }
+    }
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+    "time"
+)
+
+// Collector aggregates the damages received from damage channel as they arrive.
+// Only counters and histograms are kept, so the memory used is constant however long the test runs.
+// In raw mode, every damage is kept as well, the report then gets exact latencies and all the damages.
+//
+// Damages are bucketed by their ticks into intervals as well, which makes the time series of the report.
+// Each interval keeps a summary of a few hundred bytes, the latency quantiles of it are estimated.
+type Collector struct {
+    raw         bool
+    damages     []*Damage
+    total       *damageStats
+    targets     map[string]*damageStats // Stats of each target
+    targetNames []string                // Target names in order of the first damage
+    started     time.Time
+    interval    time.Duration
+    profile     LoadProfile             // Planned rate of the time series, nil if not in rate mode
+    series      map[int]*intervalStats  // Summary of each interval since started
+    statusCodes map[int]int             // Responses of each status code
+    statuses    map[string]int          // Responses of each status of protocols other than HTTP
+    protocols   map[string]int          // Responses of each protocol
+    errors      map[string]*ErrorCount  // Errors of each kind and cause
+    timings     *timingStats
+    corrected   *Histogram              // Latencies from the intended send time
+    dropped     int64                   // Ticks skipped in open loop, as all the workers are busy
+    late        int64                   // Ticks sent late in open loop
+}
+
+// How many distinct errors are counted, the others are counted by kind only.
+const maxDistinctErrors = 100
+
+// Summary of the damages of an interval in the time series, its size is fixed however many damages there are
+type intervalStats struct {
+    completedRequests  int
+    failedRequests     int
+    totalSentBytes     uint64
+    totalReceivedBytes uint64
+    totalLatency       time.Duration
+    minLatency         time.Duration
+    maxLatency         time.Duration
+    p50                Quantile
+    p90                Quantile
+    p99                Quantile
+}
+
+// Counters and histograms of a set of damages
+type damageStats struct {
+    completedRequests  int
+    failedRequests     int
+    totalSentBytes     uint64
+    totalReceivedBytes uint64
+    firstRequestTime   time.Time // The earliest tick
+    lastCompletedTime  time.Time // The latest response
+    latencies          *Histogram
+}
+
+// Histograms of the phases of requests, a phase is recorded only if it happened,
+// eg. DNS lookup and connect are not for reused connections.
+type timingStats struct {
+    dnsLookup         *Histogram
+    connect           *Histogram
+    tlsHandshake      *Histogram
+    handshake         *Histogram
+    firstByte         *Histogram
+    download          *Histogram
+    newConnections    int // Responses on new connections
+    reusedConnections int // Responses on reused connections
+    zeroRTT           int // Responses on QUIC connections used 0-RTT
+}
+
+// Create a collector of the damages on targets, keeps every damage if raw is true.
+// The time series starts now, with a row each interval, it's disabled if interval is not positive.
+// The planned rate of each row is taken from the profile, if it's not nil.
+func NewCollector(raw bool, targets *Targets, interval time.Duration, profile LoadProfile) *Collector {
+    c := &Collector{
+        raw: raw,
+        damages: make([]*Damage, 0),
+        total: newDamageStats(),
+        targets: make(map[string]*damageStats),
+        targetNames: make([]string, 0),
+        started: time.Now(),
+        interval: interval,
+        profile: profile,
+        series: make(map[int]*intervalStats),
+        statusCodes: make(map[int]int),
+        statuses: make(map[string]int),
+        protocols: make(map[string]int),
+        errors: make(map[string]*ErrorCount),
+        timings: newTimingStats(),
+        corrected: NewLatencyHistogram(),
+    }
+    // Keep the order of the targets in reports
+    for _, t := range targets.List() {
+        if _, ok := c.targets[t.Name()]; !ok {
+            c.targets[t.Name()] = newDamageStats()
+            c.targetNames = append(c.targetNames, t.Name())
+        }
+    }
+    return c
+}
+
+// Receive the damages come from channel
+func (c *Collector) collectDamage(damage *Damage) {
+    if c.raw {
+        c.damages = append(c.damages, damage)
+    }
+    c.total.add(damage)
+    c.timings.add(damage)
+    c.corrected.RecordDuration(damage.correctedLatency())
+
+    stats, ok := c.targets[damage.Target]
+    if !ok {
+        stats = newDamageStats()
+        c.targets[damage.Target] = stats
+        c.targetNames = append(c.targetNames, damage.Target)
+    }
+    stats.add(damage)
+
+    if damage.StatusCode > 0 {
+        c.statusCodes[damage.StatusCode]++
+    }
+    if damage.Status != "" {
+        c.statuses[damage.Status]++
+    }
+    if damage.Proto != "" {
+        c.protocols[damage.Proto]++
+    }
+    if damage.Error != "" {
+        c.countError(damage)
+    }
+
+    if c.interval > 0 && !damage.Timestamp.IsZero() {
+        idx := int(damage.Timestamp.Sub(c.started) / c.interval)
+        if idx < 0 {
+            idx = 0
+        }
+        stats, ok := c.series[idx]
+        if !ok {
+            stats = newIntervalStats()
+            c.series[idx] = stats
+        }
+        stats.add(damage)
+    }
+}
+
+// Count the ticks skipped and sent late by the missile
+func (c *Collector) collectOverflow(dropped int64, late int64) {
+    c.dropped += dropped
+    c.late += late
+}
+
+// Count the error by its kind and cause
+func (c *Collector) countError(damage *Damage) {
+    kind, cause := damage.ErrorKind, errorCause(damage.Error)
+    if kind == "" {
+        kind = errKindOther
+    }
+    key := kind + "\x00" + cause
+    if _, ok := c.errors[key]; !ok && len(c.errors) >= maxDistinctErrors {
+        cause = "(other errors)"
+        key = kind + "\x00" + cause
+    }
+    e, ok := c.errors[key]
+    if !ok {
+        e = &ErrorCount{Kind: kind, Error: cause}
+        c.errors[key] = e
+    }
+    e.Count++
+}
+
+func newDamageStats() *damageStats {
+    return &damageStats{latencies: NewLatencyHistogram()}
+}
+
+// Count a damage in
+func (s *damageStats) add(damage *Damage) {
+    s.completedRequests++
+    if damage.Error != "" {
+        s.failedRequests++
+    }
+    s.totalSentBytes += damage.SentBytes
+    s.totalReceivedBytes += damage.ReceivedBytes
+    if !damage.Timestamp.IsZero() && (s.firstRequestTime.IsZero() || damage.Timestamp.Before(s.firstRequestTime)) {
+        s.firstRequestTime = damage.Timestamp
+    }
+    if damage.EndTime.After(s.lastCompletedTime) {
+        s.lastCompletedTime = damage.EndTime
+    }
+    s.latencies.RecordDuration(damage.Latency)
+}
+
+func newIntervalStats() *intervalStats {
+    return &intervalStats{p50: NewQuantile(0.5), p90: NewQuantile(0.9), p99: NewQuantile(0.99)}
+}
+
+// Count a damage in
+func (s *intervalStats) add(damage *Damage) {
+    if s.completedRequests == 0 || damage.Latency < s.minLatency {
+        s.minLatency = damage.Latency
+    }
+    if damage.Latency > s.maxLatency {
+        s.maxLatency = damage.Latency
+    }
+    s.completedRequests++
+    if damage.Error != "" {
+        s.failedRequests++
+    }
+    s.totalSentBytes += damage.SentBytes
+    s.totalReceivedBytes += damage.ReceivedBytes
+    s.totalLatency += damage.Latency
+    latency := float64(damage.Latency)
+    s.p50.Add(latency)
+    s.p90.Add(latency)
+    s.p99.Add(latency)
+}
+
+// Time taken from the first request sent to the last response received
+func (s *damageStats) timeTaken() time.Duration {
+    return s.lastCompletedTime.Sub(s.firstRequestTime)
+}
+
+func newTimingStats() *timingStats {
+    return &timingStats{
+        dnsLookup: NewLatencyHistogram(),
+        connect: NewLatencyHistogram(),
+        tlsHandshake: NewLatencyHistogram(),
+        handshake: NewLatencyHistogram(),
+        firstByte: NewLatencyHistogram(),
+        download: NewLatencyHistogram(),
+    }
+}
+
+// Count the phases of a damage in
+func (s *timingStats) add(damage *Damage) {
+    if damage.DNSLookup > 0 {
+        s.dnsLookup.RecordDuration(damage.DNSLookup)
+    }
+    if damage.Connect > 0 {
+        s.connect.RecordDuration(damage.Connect)
+    }
+    if damage.TLSHandshake > 0 {
+        s.tlsHandshake.RecordDuration(damage.TLSHandshake)
+    }
+    if damage.Handshake > 0 {
+        s.handshake.RecordDuration(damage.Handshake)
+    }
+    if damage.StatusCode == 0 {
+        return
+    }
+    s.firstByte.RecordDuration(damage.FirstByte)
+    s.download.RecordDuration(damage.Download)
+    if damage.ConnReused {
+        s.reusedConnections++
+    } else {
+        s.newConnections++
+    }
+    if damage.Used0RTT {
+        s.zeroRTT++
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+func TestIntervalStats(t *testing.T) {
+    s := newIntervalStats()
+    for _, ms := range []int{5, 1, 9, 3, 7} {
+        d := &Damage{Latency: time.Duration(ms) * time.Millisecond, SentBytes: 10, ReceivedBytes: 20}
+        if ms == 9 {
+            d.Error = "timeout"
+        }
+        s.add(d)
+    }
+    if s.completedRequests != 5 || s.failedRequests != 1 || s.totalSentBytes != 50 || s.totalReceivedBytes != 100 {
+        t.Errorf("counts = %+v", s)
+    }
+    if s.minLatency != time.Millisecond || s.maxLatency != 9 * time.Millisecond || s.totalLatency != 25 * time.Millisecond {
+        t.Errorf("latencies min %v, max %v, total %v", s.minLatency, s.maxLatency, s.totalLatency)
+    }
+    if p50 := time.Duration(s.p50.Value()); p50 != 5 * time.Millisecond {
+        t.Errorf("p50 = %v, want 5ms", p50)
+    }
+}
+
+// Errors are counted by kind and cause, the requests they come from don't matter
+func TestCollectorCountError(t *testing.T) {
+    c := NewCollector(false, testTargets(t, "http://localhost/"), 0, nil)
+    damages := []*Damage{
+        {Error: `Get "http://localhost/a": dial tcp 127.0.0.1:80: connect: connection refused`, ErrorKind: errKindRefused},
+        {Error: `Get "http://localhost/b?id=2": dial tcp 127.0.0.1:80: connect: connection refused`, ErrorKind: errKindRefused},
+        {Error: `Post "http://localhost/c": EOF`, ErrorKind: errKindEOF},
+        {Error: "something odd"},
+    }
+    for _, d := range damages {
+        c.countError(d)
+    }
+    tests := []struct {
+        kind  string
+        cause string
+        count int
+    }{
+        {errKindRefused, "connection refused", 2},
+        {errKindEOF, "EOF", 1},
+        {errKindOther, "something odd", 1},
+    }
+    if len(c.errors) != len(tests) {
+        t.Errorf("%d distinct errors, want %d", len(c.errors), len(tests))
+    }
+    for _, tt := range tests {
+        e := c.errors[tt.kind + "\x00" + tt.cause]
+        if e == nil || e.Count != tt.count {
+            t.Errorf("%s %s counted %+v, want %d", tt.kind, tt.cause, e, tt.count)
+        }
+    }
+}
+
+// Past maxDistinctErrors, new causes are counted together by kind, the known ones still by cause
+func TestCollectorCountErrorOverflow(t *testing.T) {
+    c := NewCollector(false, testTargets(t, "http://localhost/"), 0, nil)
+    for i := 0; i < maxDistinctErrors + 10; i++ {
+        c.countError(&Damage{Error: fmt.Sprintf("status %d", i), ErrorKind: errKindStatus})
+    }
+    c.countError(&Damage{Error: "status 0", ErrorKind: errKindStatus})
+    c.countError(&Damage{Error: "read: connection reset by peer", ErrorKind: errKindReset})
+    if len(c.errors) != maxDistinctErrors + 2 {
+        t.Errorf("%d distinct errors, want %d", len(c.errors), maxDistinctErrors + 2)
+    }
+    tests := []struct {
+        kind  string
+        cause string
+        count int
+    }{
+        {errKindStatus, "status 0", 2},
+        {errKindStatus, fmt.Sprintf("status %d", maxDistinctErrors - 1), 1},
+        {errKindStatus, "(other errors)", 10},
+        {errKindReset, "(other errors)", 1},
+    }
+    for _, tt := range tests {
+        e := c.errors[tt.kind + "\x00" + tt.cause]
+        if e == nil || e.Count != tt.count {
+            t.Errorf("%s %s counted %+v, want %d", tt.kind, tt.cause, e, tt.count)
+        }
+    }
+}
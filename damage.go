@@ -5,12 +5,41 @@ import "time"
 
 // Standards for the result by launching a Missile
 type Damage struct {
+    Target        string    `json:"target"`
+    Method        string        `json:"method,omitempty"` // Method of the request sent, eg. GET
+    URL           string        `json:"url,omitempty"`    // URL of the request sent, rendered if the target is a template
     StartTime     time.Time `json:"start_time"`
     EndTime       time.Time `json:"end_time"`
     StatusCode    int        `json:"status_code"`
-    Timestamp     time.Time     `json:"timestamp"` // When a tick occur
-    Latency       time.Duration `json:"latency"`   // Round Trip Latency
+    Status        string        `json:"status,omitempty"` // Status of protocols other than HTTP, eg. Unavailable of gRPC
+    Proto         string        `json:"proto,omitempty"` // Protocol of the response, eg. HTTP/2.0
+    Timestamp     time.Time     `json:"timestamp"` // When the request is intended to be sent, StartTime may be later
+    Latency       time.Duration `json:"latency"`   // Round Trip Latency, from StartTime
     SentBytes     uint64        `json:"sent_bytes"`
     ReceivedBytes uint64        `json:"received_bytes"`
     Error         string        `json:"error"`
+    ErrorKind     string        `json:"error_kind,omitempty"` // Class of the error, eg. timeout
+    DNSLookup     time.Duration `json:"dns_lookup"`    // Zero if no lookup, eg. the connection is reused
+    Connect       time.Duration `json:"connect"`       // TCP connect, zero if the connection is reused
+    TLSHandshake  time.Duration `json:"tls_handshake"` // Zero if not https or the connection is reused, QUIC handshake over HTTP/3
+    Handshake     time.Duration `json:"handshake,omitempty"` // WebSocket upgrade, zero if the connection is reused
+    FirstByte     time.Duration `json:"first_byte"`    // From the request written to the first response byte
+    Download      time.Duration `json:"download"`      // From the first response byte to the body read
+    ConnReused    bool          `json:"conn_reused"`   // Whether the connection is a kept-alive one
+    Used0RTT      bool          `json:"used_0rtt,omitempty"` // Whether the QUIC connection used 0-RTT, over HTTP/3
+}
+
+// Latency from the intended send time, includes the delay before the request is actually sent.
+// It's not flattered by coordinated omission: a slow server delaying the following requests.
+func (d *Damage) correctedLatency() time.Duration {
+    if d.Timestamp.IsZero() || d.EndTime.IsZero() {
+        return d.Latency
+    }
+    return d.EndTime.Sub(d.Timestamp)
+}
+
+// Record the error and its class
+func (d *Damage) setError(err error) {
+    d.Error = err.Error()
+    d.ErrorKind = classifyError(err)
 }
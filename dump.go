@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+)
+
+// Dumper streams every damage to a file in JSON Lines format, one JSON object per line.
+type Dumper struct {
+    file *os.File
+    w    *bufio.Writer
+    enc  *json.Encoder
+    err  error // The first error occurred, nothing will be written after it
+}
+
+// Create a dumper writing to the specified file, the file is truncated if it exists.
+func NewDumper(file string) (*Dumper, error) {
+    f, err := os.Create(file)
+    if err != nil {
+        return nil, err
+    }
+    w := bufio.NewWriter(f)
+    return &Dumper{file: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Write a damage as a line
+func (d *Dumper) dump(damage *Damage) {
+    if d.err != nil {
+        return
+    }
+    d.err = d.enc.Encode(damage)
+}
+
+// Flush the buffered lines and close the file, returns the first error occurred while dumping.
+func (d *Dumper) Close() error {
+    if err := d.w.Flush(); err != nil && d.err == nil {
+        d.err = err
+    }
+    if err := d.file.Close(); err != nil && d.err == nil {
+        d.err = err
+    }
+    return d.err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestDumperWritesJSONLines(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "results.jsonl")
+    d, err := NewDumper(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    damages := []*Damage{
+        {Target: "GET http://localhost/a", StatusCode: 200, Latency: 3 * time.Millisecond, ReceivedBytes: 12},
+        {Target: "GET http://localhost/b", Error: "connection refused", ErrorKind: errKindRefused},
+    }
+    for _, damage := range damages {
+        d.dump(damage)
+    }
+    if err := d.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    f, err := os.Open(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    n := 0
+    for ; scanner.Scan(); n++ {
+        var got Damage
+        if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+            t.Fatalf("line %d is not JSON: %s", n + 1, err)
+        }
+        want := damages[n]
+        if got.Target != want.Target || got.StatusCode != want.StatusCode || got.Latency != want.Latency ||
+            got.Error != want.Error || got.ErrorKind != want.ErrorKind || got.ReceivedBytes != want.ReceivedBytes {
+            t.Errorf("line %d = %+v, want %+v", n + 1, got, *want)
+        }
+    }
+    if n != len(damages) {
+        t.Errorf("%d lines dumped, want %d", n, len(damages))
+    }
+}
+
+func TestDumperCreateError(t *testing.T) {
+    if _, err := NewDumper(filepath.Join(t.TempDir(), "missing", "results.jsonl")); err == nil {
+        t.Error("NewDumper in a missing directory succeeded")
+    }
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "time"
+)
+
+// Engine sends requests by a protocol, it's called by all the warheads at the same time.
+// The request is rendered from a target, engines of protocols other than HTTP take its URL, header and body.
+// The result is filled into the damage: StartTime, EndTime, Latency, status, bytes and error.
+type Engine interface {
+    Hit(req *http.Request, damage *Damage)
+}
+
+// httpEngine sends requests over HTTP/1.1, HTTP/2 or HTTP/3 by a http.Client
+type httpEngine struct {
+    ctrl   *CtrlCenter
+    client http.Client
+}
+
+// Create the HTTP engine, connections are dialed by dial.
+func newHTTPEngine(ct *CtrlCenter, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *httpEngine {
+    transport := &http.Transport{
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: dial,
+        // Bounds waiting for the response only, so a long upload or download isn't cut by it
+        ResponseHeaderTimeout: ct.Timeout,
+        // Cloned, as HTTP/2 sets the protocols of ALPN in it
+        TLSClientConfig:       ct.TLSConfig.Clone(),
+        TLSHandshakeTimeout:   10 * time.Second,
+        MaxIdleConnsPerHost:   ct.MaxIdleConnections,
+        MaxConnsPerHost:       ct.MaxConnsPerHost,
+    }
+    if ct.Http2Enable {
+        // HTTP/2 only: negotiated by ALPN over TLS, and with prior knowledge(h2c) over cleartext.
+        // The custom dialer and TLS config would disable HTTP/2 otherwise.
+        protocols := new(http.Protocols)
+        protocols.SetHTTP2(true)
+        protocols.SetUnencryptedHTTP2(true)
+        transport.Protocols = protocols
+        transport.ForceAttemptHTTP2 = true
+        transport.HTTP2 = &http.HTTP2Config{StrictMaxConcurrentRequests: ct.StrictMaxStreams}
+    }
+    e := &httpEngine{ctrl: ct}
+    e.client = http.Client{
+        Transport: transport,
+        CheckRedirect: checkRedirect(ct.MaxRedirects),
+    }
+    if ct.Http3Enable {
+        e.client.Transport = &headerTimeoutTransport{transport: newHTTP3Transport(ct), timeout: ct.Timeout}
+    }
+    return e
+}
+
+// The redirect policy of max redirects: the default of http.Client if it's 0, the redirect response is
+// the response if it's noFollow.
+func checkRedirect(max int) func(req *http.Request, via []*http.Request) error {
+    switch {
+    case max == 0:
+        return nil
+    case max < 0:
+        return func(req *http.Request, via []*http.Request) error {
+            return http.ErrUseLastResponse
+        }
+    }
+    return func(req *http.Request, via []*http.Request) error {
+        if len(via) > max {
+            return fmt.Errorf("stopped after %d redirects", max)
+        }
+        return nil
+    }
+}
+
+// headerTimeoutTransport fails the requests whose response headers don't arrive in time, like the
+// ResponseHeaderTimeout of http.Transport, for transports without it.
+type headerTimeoutTransport struct {
+    transport http.RoundTripper
+    timeout   time.Duration
+}
+
+// The response headers didn't arrive in time, it's a timeout of net.Error.
+type headerTimeoutError struct{}
+
+func (headerTimeoutError) Error() string {
+    return "timeout awaiting response headers"
+}
+
+func (headerTimeoutError) Timeout() bool {
+    return true
+}
+
+func (headerTimeoutError) Temporary() bool {
+    return true
+}
+
+func (t *headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    ctx, cancel := context.WithCancelCause(req.Context())
+    timer := time.AfterFunc(t.timeout, func() {
+        cancel(headerTimeoutError{})
+    })
+    resp, err := t.transport.RoundTrip(req.WithContext(ctx))
+    if !timer.Stop() {
+        // Fired, the response may have arrived but its body is canceled
+        if err == nil {
+            resp.Body.Close()
+        }
+        cancel(nil)
+        return nil, headerTimeoutError{}
+    }
+    if err != nil {
+        cancel(nil)
+        return nil, err
+    }
+    resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+    return resp, nil
+}
+
+// Close the idle connections of the transport, if it can
+func (t *headerTimeoutTransport) CloseIdleConnections() {
+    if c, ok := t.transport.(interface{ CloseIdleConnections() }); ok {
+        c.CloseIdleConnections()
+    }
+}
+
+// Body of a response, the context of the request is released when it's closed
+type cancelBody struct {
+    io.ReadCloser
+    cancel context.CancelCauseFunc
+}
+
+func (b *cancelBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.cancel(nil)
+    return err
+}
+
+// Do the http request
+func (e *httpEngine) Hit(req *http.Request, damage *Damage) {
+    // Trace the phases of the request
+    trace := &requestTrace{http3: e.ctrl.Http3Enable}
+    req = req.WithContext(withRequestTrace(httptrace.WithClientTrace(req.Context(), trace.clientTrace()), trace))
+    if e.ctrl.Http3Enable && e.ctrl.Allow0RTT {
+        req = http3EarlyRequest(req)
+    }
+
+    damage.StartTime = time.Now()
+    // Do http request
+    resp, err := e.client.Do(req)
+
+    // Calculate the latency
+    damage.EndTime = time.Now()
+    damage.Latency = damage.EndTime.Sub(damage.StartTime)
+
+    if err != nil {
+        trace.apply(damage, time.Time{})
+        damage.setError(err)
+        return
+    }
+    defer resp.Body.Close()
+    damage.StatusCode = resp.StatusCode
+    damage.Proto = resp.Proto
+
+    // Read the response body if it's checked, otherwise just discard it
+    var (
+        body []byte
+        in int64
+    )
+    expect := e.ctrl.Expect
+    if expect.needsBody() {
+        body, in, err = expect.readBody(resp.Body)
+    } else {
+        in, err = io.Copy(ioutil.Discard, resp.Body)
+    }
+    done := time.Now()
+    // The response is read, so the handshake of the connection dialed for it is done or about to be
+    trace.waitQUICHandshake()
+    trace.apply(damage, done)
+    if err != nil {
+        damage.setError(err)
+        return
+    }
+    // Calculate the bytes received
+    damage.ReceivedBytes = uint64(in)
+
+    // Calculate the bytes sent
+    if req.ContentLength != -1 {
+        damage.SentBytes = uint64(req.ContentLength)
+    }
+    // Calculate the err info
+    if kind, msg := expect.check(resp, body); kind != "" {
+        damage.Error = msg
+        damage.ErrorKind = kind
+    }
+}
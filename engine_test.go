@@ -0,0 +1,194 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A slow server: waits before the headers, and between the parts of the body
+func slowServer() *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow-headers" {
+            time.Sleep(300 * time.Millisecond)
+        }
+        io.Copy(ioutil.Discard, r.Body)
+        w.Write([]byte("first "))
+        w.(http.Flusher).Flush()
+        if r.URL.Path == "/slow-body" {
+            time.Sleep(300 * time.Millisecond)
+        }
+        w.Write([]byte("last"))
+    }))
+}
+
+// A body sent slowly, in parts with pauses between them
+type slowReader struct {
+    parts int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+    if r.parts == 0 {
+        return 0, io.EOF
+    }
+    r.parts--
+    time.Sleep(100 * time.Millisecond)
+    return copy(p, "part "), nil
+}
+
+// The timeout bounds waiting for the response headers, not the whole request
+func TestHTTPEngineTimeout(t *testing.T) {
+    server := slowServer()
+    defer server.Close()
+    ct := NewDefaultCtrlCenter()
+    ct.Timeout = 200 * time.Millisecond
+    dialer := &net.Dialer{Timeout: ct.Timeout}
+    engines := []struct {
+        name   string
+        engine Engine
+    }{
+        {"http.Transport", newHTTPEngine(ct, dialer.DialContext)},
+        {"headerTimeoutTransport", &httpEngine{ctrl: ct, client: http.Client{
+            Transport: &headerTimeoutTransport{transport: &http.Transport{}, timeout: ct.Timeout},
+        }}},
+    }
+    tests := []struct {
+        path    string
+        body    io.Reader
+        errKind string
+    }{
+        {"/fast", nil, ""},
+        {"/slow-body", nil, ""},
+        {"/upload", &slowReader{parts: 4}, ""},
+        {"/slow-headers", nil, errKindTimeout},
+    }
+    for _, e := range engines {
+        for _, tt := range tests {
+            req, err := http.NewRequest(http.MethodPost, server.URL + tt.path, tt.body)
+            if err != nil {
+                t.Fatal(err)
+            }
+            damage := &Damage{}
+            e.engine.Hit(req, damage)
+            if damage.ErrorKind != tt.errKind {
+                t.Errorf("%s %s: error = %s(%s), want kind %q", e.name, tt.path, damage.Error, damage.ErrorKind, tt.errKind)
+            }
+            if tt.errKind == "" && damage.ReceivedBytes != uint64(len("first last")) {
+                t.Errorf("%s %s: received %d bytes", e.name, tt.path, damage.ReceivedBytes)
+            }
+        }
+    }
+}
+
+func TestHeaderTimeoutTransportReleasesContext(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write(bytes.Repeat([]byte("x"), 1 << 16))
+    }))
+    defer server.Close()
+    transport := &headerTimeoutTransport{transport: &http.Transport{}, timeout: time.Second}
+    req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+    resp, err := transport.RoundTrip(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, err := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil || len(body) != 1 << 16 {
+        t.Errorf("read %d bytes, error %v", len(body), err)
+    }
+    if classifyError(headerTimeoutError{}) != errKindTimeout {
+        t.Error("header timeout is not classified as timeout")
+    }
+}
+
+func TestHTTPEngineRedirects(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/moved":
+            http.Redirect(w, r, "/missing", http.StatusFound)
+        case "/loop":
+            http.Redirect(w, r, "/loop", http.StatusFound)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+    tests := []struct {
+        name         string
+        maxRedirects int
+        success      string
+        path         string
+        status       int
+        err          string
+    }{
+        {"followed", 0, "200-299", "/moved", http.StatusNotFound, "404 Not Found"},
+        {"302 accepted", noFollow, "302", "/moved", http.StatusFound, ""},
+        {"3xx accepted", noFollow, "200-399", "/loop", http.StatusFound, ""},
+        {"not accepted", noFollow, "200", "/moved", http.StatusFound, "302 Found"},
+        {"too many", 2, "200-299", "/loop", 0, "stopped after 2 redirects"},
+    }
+    for _, tt := range tests {
+        ct := NewDefaultCtrlCenter()
+        ct.MaxRedirects = tt.maxRedirects
+        ranges, err := ParseStatusRanges(tt.success)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ct.Expect.StatusRanges = ranges
+        e := newHTTPEngine(ct, (&net.Dialer{}).DialContext)
+        req, _ := http.NewRequest(http.MethodGet, server.URL + tt.path, nil)
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.StatusCode != tt.status || !strings.Contains(damage.Error, tt.err) || tt.err == "" && damage.Error != "" {
+            t.Errorf("%s: status %d, error %q, want %d, %q", tt.name, damage.StatusCode, damage.Error, tt.status, tt.err)
+        }
+    }
+}
+
+// -http2 over TLS by ALPN, and over cleartext by prior knowledge(h2c)
+func TestHTTPEngineHTTP2(t *testing.T) {
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(r.Proto))
+    })
+    tlsServer := httptest.NewUnstartedServer(handler)
+    tlsServer.EnableHTTP2 = true
+    tlsServer.StartTLS()
+    defer tlsServer.Close()
+    h2cServer := httptest.NewUnstartedServer(handler)
+    h2cServer.Config.Protocols = new(http.Protocols)
+    h2cServer.Config.Protocols.SetHTTP1(true)
+    h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+    h2cServer.Start()
+    defer h2cServer.Close()
+    http1Server := httptest.NewTLSServer(handler)
+    defer http1Server.Close()
+
+    tests := []struct {
+        name  string
+        url   string
+        http2 bool
+        proto string
+    }{
+        {"tls", tlsServer.URL, true, "HTTP/2.0"},
+        {"h2c", h2cServer.URL, true, "HTTP/2.0"},
+        {"tls without -http2", http1Server.URL, false, "HTTP/1.1"},
+        {"cleartext without -http2", h2cServer.URL, false, "HTTP/1.1"},
+    }
+    for _, tt := range tests {
+        ct := NewDefaultCtrlCenter()
+        ct.Http2Enable = tt.http2
+        e := newHTTPEngine(ct, (&net.Dialer{}).DialContext)
+        req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.Error != "" || damage.Proto != tt.proto {
+            t.Errorf("%s: proto %s, error %q, want %s", tt.name, damage.Proto, damage.Error, tt.proto)
+        }
+    }
+}
@@ -1,12 +1,98 @@
 package main
 
-import "errors"
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "io"
+    "net"
+    "strings"
+    "syscall"
+)
 
 var (
-    errNilBoomOpts = errors.New("nil BoomOptions, must specified -u")
-    errBoomOpts = errors.New("Invalid BoomOptions.")
+    errNilBoomOpts = errors.New("nil BoomOptions, must specified -u or -targets")
+    errBoomOpts = errors.New("Invalid BoomOptions, must specified -u or -targets.")
     errInvalidHttpMethod = errors.New("Invalid http method.")
     errZeroRate = errors.New("rate must be bigger than zero")
     errBadCert  = errors.New("bad certificate")
+    errNoTargets = errors.New("no targets to hit")
+    errTargetsOrder = errors.New("targets order must be round-robin or random")
+    errFeederMode = errors.New("feeder mode must be one of sequential, circular and random")
+    errBodyFile = errors.New("body file must be a regular file")
+    errAuthentication = errors.New("authentication must be like username:password")
+    errAssertionsFailed = errors.New("assertions failed")
+    errReportFormat = errors.New("report format must be one of json, csv and text")
+    errNoHTTP3 = errors.New("boom is built without HTTP/3, build it with: go build -tags http3")
+    errHTTPVersion = errors.New("-http2 and -http3 can't be used together")
+    errOverflow = errors.New("overflow must be drop or queue")
+    errFindMaxMode = errors.New("-find-max searches the rate, it can't be used with -n, -profile or -closed")
+    errClosedLoopMode = errors.New("-closed runs -g virtual users for -t or -iterations, it can't be used with -n or -profile")
+    errCapacityNotFound = errors.New("no rate meets the criteria of -find-max")
+    errNoGRPC = errors.New("boom is built without gRPC, build it with: go build -tags grpc")
+    errGRPCMethod = errors.New("gRPC url must be like grpc://host:port/package.Service/Method")
+    errGRPCUnresolved = errors.New("gRPC method is not resolved before the attack, the method of url can't be a template")
+    errWSURL = errors.New("WebSocket url must be like ws://host/path or wss://host/path")
+    errWSAccept = errors.New("websocket handshake: not valid Sec-WebSocket-Accept")
+    errWSMessageSize = errors.New("websocket message is too large")
+    errWSTargets = errors.New("websocket messages are sent over the connections to one url, the targets must have the same url")
+    errEngineMode = errors.New("only one of -grpc, -ws and -socket can be used")
+    errSocketURL = errors.New("socket url must be like tcp://host:port or udp://host:port")
+    errSocketResponseSize = errors.New("socket response is too large")
+    errSocketBroken = errors.New("connection broken before the response")
 )
 
+
+// Classes of the errors of damages
+const (
+    errKindTimeout = "timeout"
+    errKindRefused = "connection refused"
+    errKindReset = "connection reset"
+    errKindTLS = "tls"
+    errKindDNS = "dns"
+    errKindEOF = "unexpected eof"
+    errKindStatus = "http status"
+    errKindGRPCStatus = "grpc status"
+    errKindWSClosed = "websocket closed"
+    errKindOther = "other"
+)
+
+// Tell the class of an error occurred while requesting
+func classifyError(err error) string {
+    var (
+        netErr      net.Error
+        dnsErr      *net.DNSError
+        recordErr   tls.RecordHeaderError
+        certErr     *tls.CertificateVerificationError
+        unknownAuth x509.UnknownAuthorityError
+        hostErr     x509.HostnameError
+        invalidErr  x509.CertificateInvalidError
+    )
+    switch {
+    case errors.As(err, &dnsErr):
+        return errKindDNS
+    case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+        return errKindTimeout
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return errKindRefused
+    case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+        return errKindReset
+    case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &unknownAuth),
+        errors.As(err, &hostErr), errors.As(err, &invalidErr), strings.Contains(err.Error(), "tls: "):
+        return errKindTLS
+    case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+        return errKindEOF
+    }
+    return errKindOther
+}
+
+// The cause of an error message without the request details, eg.
+// 'Get "http://localhost/": dial tcp 127.0.0.1:80: connect: connection refused' gives 'connection refused'.
+// So errors of the same cause are counted together.
+func errorCause(msg string) string {
+    if i := strings.LastIndex(msg, ": "); i >= 0 {
+        return msg[i + 2:]
+    }
+    return msg
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+)
+
+func TestClassifyError(t *testing.T) {
+    opErr := func(err error) error {
+        return &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", err)}
+    }
+    tests := []struct {
+        name string
+        err  error
+        want string
+    }{
+        {"dns", &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}, errKindDNS},
+        {"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "slow.invalid", IsTimeout: true}, errKindDNS},
+        {"deadline", fmt.Errorf("post: %w", context.DeadlineExceeded), errKindTimeout},
+        {"header timeout", headerTimeoutError{}, errKindTimeout},
+        {"refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+            errKindRefused},
+        {"reset", opErr(syscall.ECONNRESET), errKindReset},
+        {"broken pipe", opErr(syscall.EPIPE), errKindReset},
+        {"tls record", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, errKindTLS},
+        {"unknown authority", fmt.Errorf("get: %w", x509.UnknownAuthorityError{}), errKindTLS},
+        {"tls alert", errors.New("remote error: tls: handshake failure"), errKindTLS},
+        {"eof", fmt.Errorf("get: %w", io.EOF), errKindEOF},
+        {"unexpected eof", io.ErrUnexpectedEOF, errKindEOF},
+        {"other", errors.New("something else"), errKindOther},
+    }
+    for _, tt := range tests {
+        if got := classifyError(tt.err); got != tt.want {
+            t.Errorf("%s: classifyError(%v) = %s, want %s", tt.name, tt.err, got, tt.want)
+        }
+    }
+}
+
+// Errors of real requests
+func TestClassifyRequestErrors(t *testing.T) {
+    closed, _ := net.Listen("tcp", "127.0.0.1:0")
+    closed.Close()
+    server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer server.Close()
+    reset, _ := net.Listen("tcp", "127.0.0.1:0")
+    defer reset.Close()
+    go func() {
+        for {
+            conn, err := reset.Accept()
+            if err != nil {
+                return
+            }
+            // Closed without reading the request, with SO_LINGER 0 it's reset
+            conn.(*net.TCPConn).SetLinger(0)
+            time.Sleep(50 * time.Millisecond)
+            conn.Close()
+        }
+    }()
+    tests := []struct {
+        name string
+        url  string
+        want string
+    }{
+        {"refused", "http://" + closed.Addr().String() + "/", errKindRefused},
+        {"unknown authority", server.URL, errKindTLS},
+        {"reset", "http://" + reset.Addr().String() + "/", errKindReset},
+        {"not valid host", "http://nowhere.invalid/", errKindDNS},
+    }
+    client := &http.Client{Timeout: 5 * time.Second}
+    for _, tt := range tests {
+        _, err := client.Get(tt.url)
+        if err == nil {
+            t.Errorf("%s: no error", tt.name)
+            continue
+        }
+        if got := classifyError(err); got != tt.want {
+            t.Errorf("%s: classifyError(%v) = %s, want %s", tt.name, err, got, tt.want)
+        }
+    }
+}
+
+func TestErrorCause(t *testing.T) {
+    tests := []struct {
+        msg  string
+        want string
+    }{
+        {`Get "http://localhost/": dial tcp 127.0.0.1:80: connect: connection refused`, "connection refused"},
+        {`Post "http://localhost/a?id=1": EOF`, "EOF"},
+        {"status 503 Service Unavailable", "status 503 Service Unavailable"},
+        {"", ""},
+    }
+    for _, tt := range tests {
+        if got := errorCause(tt.msg); got != tt.want {
+            t.Errorf("errorCause(%q) = %q, want %q", tt.msg, got, tt.want)
+        }
+    }
+}
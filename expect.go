@@ -0,0 +1,281 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Classes of the failed expectations, used as the error kinds of damages
+const (
+    errKindExpectHeader = "expect header"
+    errKindExpectBody = "expect body"
+    errKindExpectJSON = "expect json"
+)
+
+// How many bytes of the response body are checked at most
+const maxCheckedBody = 10 << 20
+
+// Expectation tells whether a response is a success. A response must meet all the rules set.
+type Expectation struct {
+    StatusRanges []StatusRange  // Accepted status codes
+    Header       string         // Required response header
+    HeaderValue  string         // Required value of Header, any value if it's empty
+    BodyRegex    *regexp.Regexp // Body must match it
+    BodyContains string         // Body must contain it
+    JSON         *JSONAssertion // Assertion on the JSON body
+}
+
+// A range of status codes, both ends included
+type StatusRange struct {
+    Min int
+    Max int
+}
+
+// Create the default expectation: any 2xx status is a success.
+func NewExpectation() *Expectation {
+    return &Expectation{StatusRanges: []StatusRange{{200, 299}}}
+}
+
+// Parse status ranges like: 200-299,302
+func ParseStatusRanges(s string) ([]StatusRange, error) {
+    ranges := make([]StatusRange, 0)
+    for _, token := range strings.Split(s, ",") {
+        if token = strings.TrimSpace(token); token == "" {
+            continue
+        }
+        bounds := strings.SplitN(token, "-", 2)
+        min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+        if err != nil {
+            return nil, fmt.Errorf("not valid status code: %s", token)
+        }
+        max := min
+        if len(bounds) == 2 {
+            if max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || max < min {
+                return nil, fmt.Errorf("not valid status range: %s", token)
+            }
+        }
+        ranges = append(ranges, StatusRange{min, max})
+    }
+    if len(ranges) == 0 {
+        return nil, fmt.Errorf("no status codes in: %s", s)
+    }
+    return ranges, nil
+}
+
+// Whether the body is needed to check the response
+func (e *Expectation) needsBody() bool {
+    return e.BodyRegex != nil || e.BodyContains != "" || e.JSON != nil
+}
+
+// Read the body for checking, only the first maxCheckedBody bytes are kept. Returns the bytes read.
+func (e *Expectation) readBody(r io.Reader) ([]byte, int64, error) {
+    var buf bytes.Buffer
+    in, err := io.Copy(&buf, io.LimitReader(r, maxCheckedBody))
+    if err != nil {
+        return nil, in, err
+    }
+    rest, err := io.Copy(ioutil.Discard, r)
+    return buf.Bytes(), in + rest, err
+}
+
+// Check the response, returns the kind and message of the first rule failed, or empty strings if it's a success.
+func (e *Expectation) check(resp *http.Response, body []byte) (kind string, msg string) {
+    if !e.acceptStatus(resp.StatusCode) {
+        return errKindStatus, resp.Status
+    }
+    if e.Header != "" {
+        values, ok := resp.Header[http.CanonicalHeaderKey(e.Header)]
+        if !ok {
+            return errKindExpectHeader, "missing header " + e.Header
+        }
+        if e.HeaderValue != "" && !contains(values, e.HeaderValue) {
+            return errKindExpectHeader, fmt.Sprintf("header %s is not %s", e.Header, e.HeaderValue)
+        }
+    }
+    return e.checkBody(body)
+}
+
+// Check the body of a response, the same as check but without the status and header.
+func (e *Expectation) checkBody(body []byte) (kind string, msg string) {
+    if e.BodyRegex != nil && !e.BodyRegex.Match(body) {
+        return errKindExpectBody, "body does not match " + e.BodyRegex.String()
+    }
+    if e.BodyContains != "" && !bytes.Contains(body, []byte(e.BodyContains)) {
+        return errKindExpectBody, "body does not contain " + e.BodyContains
+    }
+    if e.JSON != nil && !e.JSON.check(body) {
+        return errKindExpectJSON, "json assertion failed " + e.JSON.String()
+    }
+    return "", ""
+}
+
+func (e *Expectation) acceptStatus(code int) bool {
+    for _, r := range e.StatusRanges {
+        if code >= r.Min && code <= r.Max {
+            return true
+        }
+    }
+    return false
+}
+
+// Whether any of the accepted status codes is a redirect
+func (e *Expectation) acceptsRedirects() bool {
+    for _, r := range e.StatusRanges {
+        if r.Min <= 399 && r.Max >= 300 {
+            return true
+        }
+    }
+    return false
+}
+
+func contains(values []string, v string) bool {
+    for _, value := range values {
+        if value == v {
+            return true
+        }
+    }
+    return false
+}
+
+// JSONAssertion checks a value in the JSON body picked by a path, eg.
+//
+//     $.status == "ok"
+//     $.items[0].id != null
+//     $.data.token
+//
+// The path is made of .key, ['key'] and [index]. Without == or !=, the value must exist.
+type JSONAssertion struct {
+    expr     string
+    path     []interface{} // string keys and int indexes
+    op       string        // ==, != or empty for existence
+    expected interface{}
+}
+
+// Parse a JSON assertion, see JSONAssertion
+func ParseJSONAssertion(expr string) (*JSONAssertion, error) {
+    a := &JSONAssertion{expr: expr}
+    pathExpr, opIdx := expr, -1
+    for _, op := range []string{"==", "!="} {
+        if i := strings.Index(expr, op); i >= 0 && (opIdx < 0 || i < opIdx) {
+            a.op, opIdx = op, i
+        }
+    }
+    if opIdx >= 0 {
+        pathExpr = expr[:opIdx]
+        if err := json.Unmarshal([]byte(strings.TrimSpace(expr[opIdx + len(a.op):])), &a.expected); err != nil {
+            return nil, fmt.Errorf("expected value of %s must be a JSON value: %s", expr, err)
+        }
+    }
+    path, err := parseJSONPath(strings.TrimSpace(pathExpr))
+    if err != nil {
+        return nil, err
+    }
+    a.path = path
+    return a, nil
+}
+
+// Parse a path like $.items[0]['the name']
+func parseJSONPath(p string) ([]interface{}, error) {
+    if !strings.HasPrefix(p, "$") {
+        return nil, fmt.Errorf("json path must start with $: %s", p)
+    }
+    path := make([]interface{}, 0)
+    rest := p[1:]
+    for rest != "" {
+        switch {
+        case rest[0] == '.':
+            end := strings.IndexAny(rest[1:], ".[")
+            if end < 0 {
+                end = len(rest) - 1
+            }
+            if end == 0 {
+                return nil, fmt.Errorf("empty key in json path: %s", p)
+            }
+            path = append(path, rest[1:end + 1])
+            rest = rest[end + 1:]
+        case strings.HasPrefix(rest, "['"):
+            end := strings.Index(rest, "']")
+            if end < 0 {
+                return nil, fmt.Errorf("unclosed [' in json path: %s", p)
+            }
+            path = append(path, rest[2:end])
+            rest = rest[end + 2:]
+        case rest[0] == '[':
+            end := strings.Index(rest, "]")
+            if end < 0 {
+                return nil, fmt.Errorf("unclosed [ in json path: %s", p)
+            }
+            idx, err := strconv.Atoi(rest[1:end])
+            if err != nil {
+                return nil, fmt.Errorf("not valid index in json path: %s", p)
+            }
+            path = append(path, idx)
+            rest = rest[end + 1:]
+        default:
+            return nil, fmt.Errorf("not valid json path: %s", p)
+        }
+    }
+    return path, nil
+}
+
+// Whether the JSON body meets the assertion
+func (a *JSONAssertion) check(body []byte) bool {
+    v, ok := lookupJSON(body, a.path)
+    if !ok {
+        return false
+    }
+    switch a.op {
+    case "==":
+        return jsonEqual(v, a.expected)
+    case "!=":
+        return !jsonEqual(v, a.expected)
+    }
+    return true
+}
+
+// The value picked by the path in the JSON body, false if the body is not JSON or the value doesn't exist.
+func lookupJSON(body []byte, path []interface{}) (interface{}, bool) {
+    var doc interface{}
+    if err := json.Unmarshal(body, &doc); err != nil {
+        return nil, false
+    }
+    v, ok := doc, true
+    for _, step := range path {
+        switch s := step.(type) {
+        case string:
+            var m map[string]interface{}
+            if m, ok = v.(map[string]interface{}); ok {
+                v, ok = m[s]
+            }
+        case int:
+            var l []interface{}
+            if l, ok = v.([]interface{}); ok {
+                if ok = s >= 0 && s < len(l); ok {
+                    v = l[s]
+                }
+            }
+        }
+        if !ok {
+            return nil, false
+        }
+    }
+    return v, true
+}
+
+func (a *JSONAssertion) String() string {
+    return a.expr
+}
+
+// Compare two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+    ja, _ := json.Marshal(a)
+    jb, _ := json.Marshal(b)
+    return bytes.Equal(ja, jb)
+}
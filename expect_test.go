@@ -0,0 +1,179 @@
+package main
+
+import (
+    "net/http"
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+func TestParseStatusRanges(t *testing.T) {
+    tests := []struct {
+        s    string
+        want []StatusRange
+        err  bool
+    }{
+        {"200", []StatusRange{{200, 200}}, false},
+        {"200-299,302", []StatusRange{{200, 299}, {302, 302}}, false},
+        {" 200 - 204 , ,404", []StatusRange{{200, 204}, {404, 404}}, false},
+        {"", nil, true},
+        {"ok", nil, true},
+        {"299-200", nil, true},
+        {"200-x", nil, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseStatusRanges(tt.s)
+        if (err != nil) != tt.err || !tt.err && !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("ParseStatusRanges(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+        }
+    }
+}
+
+func TestExpectationAcceptsRedirects(t *testing.T) {
+    tests := []struct {
+        s    string
+        want bool
+    }{
+        {"200-299", false},
+        {"200-299,302", true},
+        {"200-400", true},
+        {"399-404", true},
+        {"400-599", false},
+        {"100-299", false},
+    }
+    for _, tt := range tests {
+        ranges, err := ParseStatusRanges(tt.s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        e := &Expectation{StatusRanges: ranges}
+        if got := e.acceptsRedirects(); got != tt.want {
+            t.Errorf("acceptsRedirects(%s) = %v, want %v", tt.s, got, tt.want)
+        }
+    }
+}
+
+func TestParseJSONPath(t *testing.T) {
+    tests := []struct {
+        p    string
+        want []interface{}
+        err  bool
+    }{
+        {"$", []interface{}{}, false},
+        {"$.status", []interface{}{"status"}, false},
+        {"$.items[0].id", []interface{}{"items", 0, "id"}, false},
+        {"$['the name'][12]", []interface{}{"the name", 12}, false},
+        {"$.a.b['c.d']", []interface{}{"a", "b", "c.d"}, false},
+        {"status", nil, true},
+        {"$..a", nil, true},
+        {"$.items[x]", nil, true},
+        {"$.items[0", nil, true},
+        {"$['a", nil, true},
+        {"$a", nil, true},
+    }
+    for _, tt := range tests {
+        got, err := parseJSONPath(tt.p)
+        if (err != nil) != tt.err || !tt.err && !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("parseJSONPath(%q) = %v, %v, want %v", tt.p, got, err, tt.want)
+        }
+    }
+}
+
+func TestJSONAssertion(t *testing.T) {
+    body := []byte(`{"status":"ok","count":3,"items":[{"id":7,"tags":["a"]}],"token":null,"user":{"name":"ann"}}`)
+    tests := []struct {
+        expr string
+        want bool
+    }{
+        {`$.status == "ok"`, true},
+        {`$.status == "fail"`, false},
+        {`$.status != "fail"`, true},
+        {`$.count == 3`, true},
+        {`$.count == 3.0`, true},
+        {`$.count == "3"`, false},
+        {`$.items[0].id == 7`, true},
+        {`$.items[0].tags == ["a"]`, true},
+        {`$.items[1].id`, false},
+        {`$.items[-1]`, false},
+        {`$.token == null`, true},
+        {`$.token`, true},
+        {`$.missing`, false},
+        {`$.missing != 1`, false},
+        {`$['user'].name == "ann"`, true},
+        {`$.user == {"name":"ann"}`, true},
+        {`$.status.length`, false},
+    }
+    for _, tt := range tests {
+        a, err := ParseJSONAssertion(tt.expr)
+        if err != nil {
+            t.Errorf("ParseJSONAssertion(%q): %s", tt.expr, err)
+            continue
+        }
+        if got := a.check(body); got != tt.want {
+            t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+    if a, _ := ParseJSONAssertion("$.status"); a.check([]byte("not json")) {
+        t.Error("assertion holds on a body which is not JSON")
+    }
+}
+
+func TestParseJSONAssertionErrors(t *testing.T) {
+    for _, expr := range []string{`$.status == ok`, `status == "ok"`, `$.a ==`, `$[0`} {
+        if _, err := ParseJSONAssertion(expr); err == nil {
+            t.Errorf("ParseJSONAssertion(%q): no error", expr)
+        }
+    }
+}
+
+func TestExpectationCheck(t *testing.T) {
+    okJSON, _ := ParseJSONAssertion(`$.ok == true`)
+    tests := []struct {
+        name   string
+        expect *Expectation
+        status int
+        header http.Header
+        body   string
+        kind   string
+    }{
+        {"default 2xx", NewExpectation(), 204, nil, "", ""},
+        {"default rejects 3xx", NewExpectation(), 302, nil, "", errKindStatus},
+        {"status ranges", &Expectation{StatusRanges: []StatusRange{{200, 200}, {404, 404}}}, 404, nil, "", ""},
+        {"header present", &Expectation{StatusRanges: []StatusRange{{200, 299}}, Header: "x-cache"}, 200,
+            http.Header{"X-Cache": {"HIT"}}, "", ""},
+        {"header missing", &Expectation{StatusRanges: []StatusRange{{200, 299}}, Header: "X-Cache"}, 200,
+            nil, "", errKindExpectHeader},
+        {"header value", &Expectation{StatusRanges: []StatusRange{{200, 299}}, Header: "X-Cache", HeaderValue: "HIT"}, 200,
+            http.Header{"X-Cache": {"MISS", "HIT"}}, "", ""},
+        {"header wrong value", &Expectation{StatusRanges: []StatusRange{{200, 299}}, Header: "X-Cache", HeaderValue: "HIT"}, 200,
+            http.Header{"X-Cache": {"MISS"}}, "", errKindExpectHeader},
+        {"body regex", &Expectation{StatusRanges: []StatusRange{{200, 299}}, BodyRegex: regexp.MustCompile(`id=\d+`)}, 200,
+            nil, "id=42", ""},
+        {"body regex fails", &Expectation{StatusRanges: []StatusRange{{200, 299}}, BodyRegex: regexp.MustCompile(`id=\d+`)}, 200,
+            nil, "id=x", errKindExpectBody},
+        {"body contains", &Expectation{StatusRanges: []StatusRange{{200, 299}}, BodyContains: "welcome"}, 200,
+            nil, "<h1>welcome</h1>", ""},
+        {"body contains fails", &Expectation{StatusRanges: []StatusRange{{200, 299}}, BodyContains: "welcome"}, 200,
+            nil, "<h1>bye</h1>", errKindExpectBody},
+        {"json", &Expectation{StatusRanges: []StatusRange{{200, 299}}, JSON: okJSON}, 200, nil, `{"ok":true}`, ""},
+        {"json fails", &Expectation{StatusRanges: []StatusRange{{200, 299}}, JSON: okJSON}, 200, nil, `{"ok":false}`,
+            errKindExpectJSON},
+        {"status before body", &Expectation{StatusRanges: []StatusRange{{200, 299}}, JSON: okJSON}, 500, nil, `{}`,
+            errKindStatus},
+    }
+    for _, tt := range tests {
+        resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Header: tt.header}
+        if kind, msg := tt.expect.check(resp, []byte(tt.body)); kind != tt.kind {
+            t.Errorf("%s: kind = %q(%s), want %q", tt.name, kind, msg, tt.kind)
+        }
+    }
+}
+
+func TestExpectationReadBody(t *testing.T) {
+    e := NewExpectation()
+    body, n, err := e.readBody(strings.NewReader(strings.Repeat("x", maxCheckedBody + 10)))
+    if err != nil || len(body) != maxCheckedBody || n != maxCheckedBody + 10 {
+        t.Errorf("read %d of %d bytes, error %v", len(body), n, err)
+    }
+}
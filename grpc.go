@@ -0,0 +1,292 @@
+//go:build grpc
+
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/metadata"
+    reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protodesc"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/reflect/protoregistry"
+    "google.golang.org/protobuf/types/descriptorpb"
+    "google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Whether boom is built with gRPC support
+const grpcSupported = true
+
+// Headers of the request which are not sent as gRPC metadata
+var grpcSkippedHeaders = map[string]bool{
+    "Content-Type": true,
+    "Content-Length": true,
+    "User-Agent": true,
+}
+
+// grpcEngine calls unary gRPC methods. The target URL is like grpc://host:port/package.Service/Method,
+// grpcs:// for TLS. The request body is the JSON of the request message, and the headers are sent as metadata.
+// The connections and methods of all the targets are made before the attack, they are only read by the warheads.
+type grpcEngine struct {
+    ctrl     *CtrlCenter
+    dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+    protoset *protoregistry.Files                     // Descriptors of -grpc-protoset, nil to use server reflection
+    conns    map[string]*grpc.ClientConn             // Connection of each scheme and host
+    methods  map[string]protoreflect.MethodDescriptor // Method of each URL, without the query
+}
+
+// Create the gRPC engine, the methods are described by the protoset file or server reflection if it's empty.
+// The protoset is made by: protoc --include_imports -o FILE
+// The methods of the targets are resolved now, so an unknown method or a failed reflection fails before the attack.
+func newGRPCEngine(ct *CtrlCenter, dial func(ctx context.Context, network, addr string) (net.Conn, error),
+    protoset string, targets *Targets) (Engine, error) {
+
+    e := &grpcEngine{
+        ctrl: ct,
+        dial: dial,
+        conns: make(map[string]*grpc.ClientConn),
+        methods: make(map[string]protoreflect.MethodDescriptor),
+    }
+    if protoset != "" {
+        data, err := ioutil.ReadFile(protoset)
+        if err != nil {
+            return nil, err
+        }
+        set := &descriptorpb.FileDescriptorSet{}
+        if err := proto.Unmarshal(data, set); err != nil {
+            return nil, fmt.Errorf("not valid protoset %s: %s", protoset, err)
+        }
+        if e.protoset, err = protodesc.NewFiles(set); err != nil {
+            return nil, fmt.Errorf("not valid protoset %s: %s", protoset, err)
+        }
+    }
+    // Files of each host and service described by server reflection
+    reflected := make(map[string]*protoregistry.Files)
+    for _, t := range targets.List() {
+        u, err := url.Parse(t.Url)
+        if err != nil {
+            return nil, err
+        }
+        if err := e.resolve(u, reflected); err != nil {
+            return nil, fmt.Errorf("%s: %s", t.Name(), err)
+        }
+    }
+    return e, nil
+}
+
+// Call the method
+func (e *grpcEngine) Hit(req *http.Request, damage *Damage) {
+    host := req.URL.Scheme + "://" + req.URL.Host
+    conn, md := e.conns[host], e.methods[host + req.URL.Path]
+    if conn == nil || md == nil {
+        damage.setError(errGRPCUnresolved)
+        return
+    }
+    in := dynamicpb.NewMessage(md.Input())
+    if req.Body != nil {
+        body, err := ioutil.ReadAll(req.Body)
+        req.Body.Close()
+        if err != nil {
+            damage.setError(err)
+            return
+        }
+        if len(strings.TrimSpace(string(body))) > 0 {
+            if err := protojson.Unmarshal(body, in); err != nil {
+                damage.setError(fmt.Errorf("not valid %s: %s", md.Input().FullName(), err))
+                return
+            }
+        }
+    }
+    out := dynamicpb.NewMessage(md.Output())
+
+    ctx, cancel := context.WithTimeout(req.Context(), e.ctrl.Timeout)
+    defer cancel()
+    header := metadata.MD{}
+    for k, values := range req.Header {
+        if !grpcSkippedHeaders[k] {
+            header.Append(k, values...)
+        }
+    }
+    ctx = metadata.NewOutgoingContext(ctx, header)
+
+    damage.StartTime = time.Now()
+    err := conn.Invoke(ctx, "/" + string(md.Parent().FullName()) + "/" + string(md.Name()), in, out)
+    damage.EndTime = time.Now()
+    damage.Latency = damage.EndTime.Sub(damage.StartTime)
+
+    damage.Proto = "gRPC"
+    damage.SentBytes = uint64(proto.Size(in))
+    st := status.Convert(err)
+    damage.Status = st.Code().String()
+    if err != nil {
+        damage.Error = st.Message()
+        damage.ErrorKind = errKindGRPCStatus
+        if st.Code() == codes.DeadlineExceeded {
+            damage.ErrorKind = errKindTimeout
+        }
+        return
+    }
+    damage.ReceivedBytes = uint64(proto.Size(out))
+
+    // Check the JSON of the response message
+    if expect := e.ctrl.Expect; expect.needsBody() {
+        body, err := protojson.Marshal(out)
+        if err != nil {
+            damage.setError(err)
+            return
+        }
+        if kind, msg := expect.checkBody(body); kind != "" {
+            damage.Error = msg
+            damage.ErrorKind = kind
+        }
+    }
+}
+
+// The connection to the host of the URL, it's shared by all the warheads.
+func (e *grpcEngine) conn(u *url.URL) (*grpc.ClientConn, error) {
+    key := u.Scheme + "://" + u.Host
+    if conn, ok := e.conns[key]; ok {
+        return conn, nil
+    }
+    var creds credentials.TransportCredentials
+    switch u.Scheme {
+    case "grpc", "http":
+        creds = insecure.NewCredentials()
+    case "grpcs", "https":
+        creds = credentials.NewTLS(e.ctrl.TLSConfig)
+    default:
+        return nil, errGRPCMethod
+    }
+    conn, err := grpc.NewClient("passthrough:///" + u.Host,
+        grpc.WithTransportCredentials(creds),
+        grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+            return e.dial(ctx, "tcp", addr)
+        }))
+    if err != nil {
+        return nil, err
+    }
+    e.conns[key] = conn
+    return conn, nil
+}
+
+// Make the connection of the URL and find the method of its path, described by the protoset or server reflection.
+// The files reflected are cached in reflected by host and service.
+func (e *grpcEngine) resolve(u *url.URL, reflected map[string]*protoregistry.Files) error {
+    key := u.Scheme + "://" + u.Host + u.Path
+    if _, ok := e.methods[key]; ok {
+        return nil
+    }
+    conn, err := e.conn(u)
+    if err != nil {
+        return err
+    }
+    parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+    if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+        return errGRPCMethod
+    }
+    files := e.protoset
+    if files == nil {
+        if files = reflected[u.Host + "/" + parts[0]]; files == nil {
+            if files, err = reflectFiles(conn, parts[0], e.ctrl.Timeout); err != nil {
+                return fmt.Errorf("server reflection of %s on %s, set -grpc-protoset if the server doesn't " +
+                    "enable it: %s", parts[0], u.Host, err)
+            }
+            reflected[u.Host + "/" + parts[0]] = files
+        }
+    }
+    d, err := files.FindDescriptorByName(protoreflect.FullName(parts[0]))
+    if err != nil {
+        return fmt.Errorf("service %s not found", parts[0])
+    }
+    service, ok := d.(protoreflect.ServiceDescriptor)
+    if !ok {
+        return fmt.Errorf("%s is not a service", parts[0])
+    }
+    md := service.Methods().ByName(protoreflect.Name(parts[1]))
+    if md == nil {
+        return fmt.Errorf("method %s not found in %s", parts[1], parts[0])
+    }
+    if md.IsStreamingClient() || md.IsStreamingServer() {
+        return fmt.Errorf("%s is a streaming method, only unary methods are supported", md.FullName())
+    }
+    e.methods[key] = md
+    return nil
+}
+
+// Fetch the file of the service and all its dependencies by server reflection, it fails after the timeout.
+// Dependencies the server doesn't know, eg. well-known types, are taken from the ones linked in boom.
+func reflectFiles(conn *grpc.ClientConn, service string, timeout time.Duration) (*protoregistry.Files, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer stream.CloseSend()
+
+    set := &descriptorpb.FileDescriptorSet{}
+    seen := make(map[string]bool)
+    // Each response holds the file asked and maybe some of its dependencies
+    ask := func(req *reflectionpb.ServerReflectionRequest) error {
+        if err := stream.Send(req); err != nil {
+            return err
+        }
+        resp, err := stream.Recv()
+        if err != nil {
+            return err
+        }
+        if e := resp.GetErrorResponse(); e != nil {
+            return status.Error(codes.Code(e.ErrorCode), e.ErrorMessage)
+        }
+        for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+            fd := &descriptorpb.FileDescriptorProto{}
+            if err := proto.Unmarshal(data, fd); err != nil {
+                return err
+            }
+            if !seen[fd.GetName()] {
+                seen[fd.GetName()] = true
+                set.File = append(set.File, fd)
+            }
+        }
+        return nil
+    }
+    err = ask(&reflectionpb.ServerReflectionRequest{
+        MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+    })
+    if err != nil {
+        return nil, err
+    }
+    for i := 0; i < len(set.File); i++ {
+        for _, dep := range set.File[i].GetDependency() {
+            if seen[dep] {
+                continue
+            }
+            if fd, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+                seen[dep] = true
+                set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+                continue
+            }
+            err := ask(&reflectionpb.ServerReflectionRequest{
+                MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+            })
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    return protodesc.NewFiles(set)
+}
@@ -0,0 +1,17 @@
+//go:build !grpc
+
+package main
+
+import (
+    "context"
+    "net"
+)
+
+// Whether boom is built with gRPC support
+const grpcSupported = false
+
+// gRPC needs grpc-go, boom is built with it by the grpc tag. checkOpts refuses -grpc without it.
+func newGRPCEngine(ct *CtrlCenter, dial func(ctx context.Context, network, addr string) (net.Conn, error),
+    protoset string, targets *Targets) (Engine, error) {
+    return nil, errNoGRPC
+}
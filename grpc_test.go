@@ -0,0 +1,195 @@
+//go:build grpc
+
+package main
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/health"
+    healthpb "google.golang.org/grpc/health/grpc_health_v1"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/reflection"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protodesc"
+    "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Health service failing the calls with the x-fail metadata
+type failingHealth struct {
+    *health.Server
+}
+
+func (s failingHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+    if md, _ := metadata.FromIncomingContext(ctx); len(md["x-fail"]) > 0 {
+        return nil, status.Error(codes.Unavailable, "told to fail")
+    }
+    return s.Server.Check(ctx, req)
+}
+
+// Start a local gRPC server of the health service, with server reflection if reflect is true
+func startGRPCServer(t *testing.T, reflect bool) string {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := grpc.NewServer()
+    healthpb.RegisterHealthServer(server, failingHealth{health.NewServer()})
+    if reflect {
+        reflection.Register(server)
+    }
+    go server.Serve(l)
+    t.Cleanup(server.Stop)
+    return l.Addr().String()
+}
+
+func grpcTargets(t *testing.T, urls ...string) *Targets {
+    list := make([]*Target, 0, len(urls))
+    for _, u := range urls {
+        list = append(list, NewTarget(u))
+    }
+    targets, err := NewTargets(list, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return targets
+}
+
+func newTestGRPCEngine(t *testing.T, protoset string, urls ...string) (Engine, error) {
+    ct := NewDefaultCtrlCenter()
+    ct.Timeout = 2 * time.Second
+    return newGRPCEngine(ct, (&net.Dialer{}).DialContext, protoset, grpcTargets(t, urls...))
+}
+
+func TestGRPCEngineHit(t *testing.T) {
+    url := "grpc://" + startGRPCServer(t, true) + "/grpc.health.v1.Health/Check"
+    e, err := newTestGRPCEngine(t, "", url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name   string
+        body   string
+        fail   bool
+        status string
+        kind   string
+    }{
+        {"serving", `{"service":""}`, false, "OK", ""},
+        {"empty body", ``, false, "OK", ""},
+        {"unknown service", `{"service":"nope"}`, false, "NotFound", errKindGRPCStatus},
+        {"failed", `{}`, true, "Unavailable", errKindGRPCStatus},
+        {"not valid body", `{"nope":1}`, false, "", errKindOther},
+    }
+    for _, tt := range tests {
+        req, _ := http.NewRequest(http.MethodGet, url, strings.NewReader(tt.body))
+        if tt.fail {
+            req.Header.Set("X-Fail", "1")
+        }
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.Status != tt.status || damage.ErrorKind != tt.kind {
+            t.Errorf("%s: status %s, error %s(%s), want %s(%s)", tt.name, damage.Status, damage.Error,
+                damage.ErrorKind, tt.status, tt.kind)
+        }
+    }
+}
+
+// The warheads call at the same time, nothing is resolved on the way
+func TestGRPCEngineConcurrentHits(t *testing.T) {
+    url := "grpc://" + startGRPCServer(t, true) + "/grpc.health.v1.Health/Check"
+    e, err := newTestGRPCEngine(t, "", url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 50; i++ {
+                req, _ := http.NewRequest(http.MethodGet, url, nil)
+                damage := &Damage{}
+                e.Hit(req, damage)
+                if damage.Status != "OK" {
+                    t.Errorf("status %s, error %s", damage.Status, damage.Error)
+                    return
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    // A URL not resolved before the attack, eg. rendered by a template
+    req, _ := http.NewRequest(http.MethodGet, strings.Replace(url, "Check", "Watch", 1), nil)
+    damage := &Damage{}
+    e.Hit(req, damage)
+    if damage.Error != errGRPCUnresolved.Error() {
+        t.Errorf("unresolved method: error = %s", damage.Error)
+    }
+}
+
+// Methods are resolved when the engine is created, so bad ones fail before the attack
+func TestGRPCEngineResolveErrors(t *testing.T) {
+    addr := startGRPCServer(t, true)
+    noReflection := startGRPCServer(t, false)
+    closed, _ := net.Listen("tcp", "127.0.0.1:0")
+    closed.Close()
+    tests := []struct {
+        name string
+        url  string
+        err  string
+    }{
+        {"no method", "grpc://" + addr + "/grpc.health.v1.Health", "grpc://host:port/package.Service/Method"},
+        {"bad scheme", "http2://" + addr + "/grpc.health.v1.Health/Check", "grpc://host:port/package.Service/Method"},
+        {"unknown service", "grpc://" + addr + "/boom.Nope/Check", "server reflection of boom.Nope"},
+        {"unknown method", "grpc://" + addr + "/grpc.health.v1.Health/Nope", "method Nope not found"},
+        {"streaming", "grpc://" + addr + "/grpc.health.v1.Health/Watch", "streaming method"},
+        {"no reflection", "grpc://" + noReflection + "/grpc.health.v1.Health/Check", "-grpc-protoset"},
+        {"no server", "grpc://" + closed.Addr().String() + "/grpc.health.v1.Health/Check", "connection refused"},
+    }
+    for _, tt := range tests {
+        start := time.Now()
+        _, err := newTestGRPCEngine(t, "", tt.url)
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.err)
+        }
+        if time.Since(start) > time.Second {
+            t.Errorf("%s: failed after %v", tt.name, time.Since(start))
+        }
+    }
+}
+
+func TestGRPCEngineProtoset(t *testing.T) {
+    set := &descriptorpb.FileDescriptorSet{
+        File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)},
+    }
+    data, err := proto.Marshal(set)
+    if err != nil {
+        t.Fatal(err)
+    }
+    protoset := filepath.Join(t.TempDir(), "health.protoset")
+    if err := os.WriteFile(protoset, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+    url := "grpc://" + startGRPCServer(t, false) + "/grpc.health.v1.Health/Check"
+    e, err := newTestGRPCEngine(t, protoset, url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req, _ := http.NewRequest(http.MethodGet, url, nil)
+    damage := &Damage{}
+    e.Hit(req, damage)
+    if damage.Status != "OK" {
+        t.Errorf("status %s, error %s", damage.Status, damage.Error)
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "math"
+    "time"
+)
+
+const (
+    // Sub-bucket bits of the latency histograms, 2^8 sub-buckets keeps the error below 1%.
+    defaultHistogramBits = 8
+    // The highest latency the histograms can tell apart, bigger ones are counted as this.
+    defaultHistogramHighest = int64(time.Hour)
+)
+
+// Histogram is a HDR(High Dynamic Range) histogram of int64 values.
+// Values are counted in buckets growing exponentially, each of them is split into
+// 2^bits linear sub-buckets, so the memory is constant while the relative error stays
+// below 1/2^(bits-1) for any value in [0, highest].
+type Histogram struct {
+    bits    uint
+    highest int64
+    counts  []uint64
+    total   uint64
+    min     int64
+    max     int64
+    mean    float64
+    m2      float64 // Sum of squares of differences from the mean
+}
+
+// Create a histogram with 2^bits sub-buckets tracking values up to highest.
+func NewHistogram(bits uint, highest int64) *Histogram {
+    h := &Histogram{bits: bits, highest: highest}
+    h.counts = make([]uint64, h.indexOf(highest) + 1)
+    h.Reset()
+    return h
+}
+
+// Create a histogram fits for latencies in nanoseconds
+func NewLatencyHistogram() *Histogram {
+    return NewHistogram(defaultHistogramBits, defaultHistogramHighest)
+}
+
+// Record a value
+func (h *Histogram) Record(v int64) {
+    if v < 0 {
+        v = 0
+    }
+    if h.total == 0 || v < h.min {
+        h.min = v
+    }
+    if h.total == 0 || v > h.max {
+        h.max = v
+    }
+    idx := h.indexOf(v)
+    if idx >= len(h.counts) {
+        idx = len(h.counts) - 1
+    }
+    h.counts[idx]++
+    h.total++
+    // Welford's online algorithm for mean and variance
+    d := float64(v) - h.mean
+    h.mean += d / float64(h.total)
+    h.m2 += d * (float64(v) - h.mean)
+}
+
+// Record a duration
+func (h *Histogram) RecordDuration(d time.Duration) {
+    h.Record(int64(d))
+}
+
+// How many values are recorded
+func (h *Histogram) Count() uint64 {
+    return h.total
+}
+
+// The smallest recorded value
+func (h *Histogram) Min() int64 {
+    return h.min
+}
+
+// The biggest recorded value
+func (h *Histogram) Max() int64 {
+    return h.max
+}
+
+// The mean of recorded values
+func (h *Histogram) Mean() float64 {
+    return h.mean
+}
+
+// The standard deviation of recorded values
+func (h *Histogram) StdDev() float64 {
+    if h.total == 0 {
+        return 0
+    }
+    return math.Sqrt(h.m2 / float64(h.total))
+}
+
+// The value at the given percentile, eg. ValueAt(99.9)
+func (h *Histogram) ValueAt(percent float64) int64 {
+    if h.total == 0 {
+        return 0
+    }
+    rank := uint64(math.Ceil(percent / 100 * float64(h.total)))
+    if rank < 1 {
+        rank = 1
+    }
+    seen := uint64(0)
+    for idx, c := range h.counts {
+        if seen += c; seen >= rank {
+            return h.clamp(h.highestEquivalentOf(idx))
+        }
+    }
+    return h.max
+}
+
+// Merge adds all values recorded by o into h, both must have the same layout.
+func (h *Histogram) Merge(o *Histogram) {
+    if o.total == 0 {
+        return
+    }
+    if h.total == 0 || o.min < h.min {
+        h.min = o.min
+    }
+    if h.total == 0 || o.max > h.max {
+        h.max = o.max
+    }
+    for idx, c := range o.counts {
+        h.counts[idx] += c
+    }
+    // Chan's parallel algorithm for mean and variance
+    total := h.total + o.total
+    d := o.mean - h.mean
+    h.m2 += o.m2 + d * d * float64(h.total) * float64(o.total) / float64(total)
+    h.mean += d * float64(o.total) / float64(total)
+    h.total = total
+}
+
+// Reset clears all recorded values
+func (h *Histogram) Reset() {
+    for idx := range h.counts {
+        h.counts[idx] = 0
+    }
+    h.total, h.min, h.max, h.mean, h.m2 = 0, 0, 0, 0, 0
+}
+
+// ForEach calls fn with the middle value and count of every non-empty bucket, in ascending order.
+func (h *Histogram) ForEach(fn func(value int64, count uint64)) {
+    for idx, c := range h.counts {
+        if c == 0 {
+            continue
+        }
+        lowest, highest := h.lowestEquivalentOf(idx), h.highestEquivalentOf(idx)
+        fn(h.clamp(lowest + (highest - lowest) / 2), c)
+    }
+}
+
+// Values below 2^bits are counted one per bucket, above it every power of two
+// range is split into 2^(bits-1) buckets.
+func (h *Histogram) indexOf(v int64) int {
+    subBuckets := int64(1) << h.bits
+    if v < subBuckets {
+        return int(v)
+    }
+    shift := bitLen(v) - h.bits
+    sub := v >> shift
+    return int(subBuckets + int64(shift - 1) * (subBuckets / 2) + (sub - subBuckets / 2))
+}
+
+// The lowest value counted in the bucket
+func (h *Histogram) lowestEquivalentOf(idx int) int64 {
+    subBuckets := 1 << h.bits
+    if idx < subBuckets {
+        return int64(idx)
+    }
+    half := subBuckets / 2
+    shift := uint((idx - subBuckets) / half + 1)
+    sub := int64((idx - subBuckets) % half + half)
+    return sub << shift
+}
+
+// The highest value counted in the bucket
+func (h *Histogram) highestEquivalentOf(idx int) int64 {
+    subBuckets := 1 << h.bits
+    if idx < subBuckets {
+        return int64(idx)
+    }
+    shift := uint((idx - subBuckets) / (subBuckets / 2) + 1)
+    return h.lowestEquivalentOf(idx) + (int64(1) << shift) - 1
+}
+
+// Keep the value in [min, max] so the bucket resolution never exceeds the recorded range
+func (h *Histogram) clamp(v int64) int64 {
+    if v < h.min {
+        return h.min
+    }
+    if v > h.max {
+        return h.max
+    }
+    return v
+}
+
+// The number of bits needed to represent v
+func bitLen(v int64) uint {
+    n := uint(0)
+    for ; v > 0; v >>= 1 {
+        n++
+    }
+    return n
+}
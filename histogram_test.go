@@ -0,0 +1,116 @@
+package main
+
+import (
+    "math"
+    "testing"
+    "time"
+)
+
+func TestHistogramValueAt(t *testing.T) {
+    h := NewLatencyHistogram()
+    // 1ms to 10s, one value each millisecond
+    for v := int64(1); v <= 10000; v++ {
+        h.RecordDuration(time.Duration(v) * time.Millisecond)
+    }
+    tests := []struct {
+        percent float64
+        want    time.Duration
+    }{
+        {0, time.Millisecond},
+        {50, 5000 * time.Millisecond},
+        {90, 9000 * time.Millisecond},
+        {99, 9900 * time.Millisecond},
+        {99.9, 9990 * time.Millisecond},
+        {100, 10000 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        got := time.Duration(h.ValueAt(tt.percent))
+        if diff := math.Abs(float64(got - tt.want)) / float64(tt.want); diff > 0.01 {
+            t.Errorf("ValueAt(%v) = %v, want %v within 1%%", tt.percent, got, tt.want)
+        }
+    }
+    if h.Count() != 10000 {
+        t.Errorf("Count() = %d, want 10000", h.Count())
+    }
+    if h.Min() != int64(time.Millisecond) || h.Max() != int64(10000 * time.Millisecond) {
+        t.Errorf("Min(), Max() = %d, %d", h.Min(), h.Max())
+    }
+    if mean := time.Duration(h.Mean()); mean != 5000500 * time.Microsecond {
+        t.Errorf("Mean() = %v, want 5.0005s", mean)
+    }
+}
+
+func TestHistogramEmpty(t *testing.T) {
+    h := NewLatencyHistogram()
+    if h.Count() != 0 || h.ValueAt(99) != 0 || h.Mean() != 0 || h.StdDev() != 0 || h.Min() != 0 || h.Max() != 0 {
+        t.Errorf("empty histogram: count %d, p99 %d, mean %v, stddev %v, min %d, max %d",
+            h.Count(), h.ValueAt(99), h.Mean(), h.StdDev(), h.Min(), h.Max())
+    }
+    called := false
+    h.ForEach(func(value int64, count uint64) {
+        called = true
+    })
+    if called {
+        t.Error("ForEach called on an empty histogram")
+    }
+}
+
+func TestHistogramOutOfRange(t *testing.T) {
+    h := NewHistogram(defaultHistogramBits, int64(time.Second))
+    tests := []struct {
+        name   string
+        v      int64
+        wantAt int64
+    }{
+        {"negative counted as zero", -5, 0},
+        {"zero", 0, 0},
+        {"above highest kept as max", int64(time.Minute), int64(time.Minute)},
+    }
+    for _, tt := range tests {
+        h.Reset()
+        h.Record(tt.v)
+        if got := h.ValueAt(100); got != tt.wantAt {
+            t.Errorf("%s: ValueAt(100) = %d, want %d", tt.name, got, tt.wantAt)
+        }
+    }
+}
+
+func TestHistogramBuckets(t *testing.T) {
+    h := NewLatencyHistogram()
+    for _, v := range []int64{0, 1, 255, 256, 257, 511, 512, 1000, 123456789, int64(time.Hour)} {
+        idx := h.indexOf(v)
+        lowest, highest := h.lowestEquivalentOf(idx), h.highestEquivalentOf(idx)
+        if v < lowest || v > highest {
+            t.Errorf("%d is in bucket %d of [%d, %d]", v, idx, lowest, highest)
+        }
+        if width := highest - lowest; v > 0 && float64(width) / float64(v) > 1.0 / (1 << (defaultHistogramBits - 1)) {
+            t.Errorf("bucket of %d spans %d, error above 1%%", v, width)
+        }
+    }
+}
+
+func TestHistogramMerge(t *testing.T) {
+    all, a, b := NewLatencyHistogram(), NewLatencyHistogram(), NewLatencyHistogram()
+    for v := int64(1); v <= 2000; v++ {
+        all.Record(v * 1000)
+        if v % 3 == 0 {
+            a.Record(v * 1000)
+        } else {
+            b.Record(v * 1000)
+        }
+    }
+    a.Merge(b)
+    a.Merge(NewLatencyHistogram())
+    if a.Count() != all.Count() || a.Min() != all.Min() || a.Max() != all.Max() {
+        t.Errorf("merged count, min, max = %d, %d, %d, want %d, %d, %d",
+            a.Count(), a.Min(), a.Max(), all.Count(), all.Min(), all.Max())
+    }
+    if math.Abs(a.Mean() - all.Mean()) > 1e-6 || math.Abs(a.StdDev() - all.StdDev()) > 1e-6 {
+        t.Errorf("merged mean, stddev = %v, %v, want %v, %v", a.Mean(), a.StdDev(), all.Mean(), all.StdDev())
+    }
+    for _, p := range []float64{50, 90, 99, 99.9} {
+        if a.ValueAt(p) != all.ValueAt(p) {
+            t.Errorf("merged ValueAt(%v) = %d, want %d", p, a.ValueAt(p), all.ValueAt(p))
+        }
+    }
+}
@@ -0,0 +1,64 @@
+//go:build http3
+
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "net/http"
+    "time"
+
+    "github.com/quic-go/quic-go"
+    "github.com/quic-go/quic-go/http3"
+)
+
+// Whether boom is built with HTTP/3 support
+const http3Supported = true
+
+// Create the transport sending requests over HTTP/3. The local addresses and dialers of the missile are not used,
+// the QUIC connections are dialed by quic-go. Sessions are cached for resumption, so 0-RTT can be used.
+func newHTTP3Transport(ct *CtrlCenter) http.RoundTripper {
+    tlsConfig := ct.TLSConfig.Clone()
+    tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+    return &http3.Transport{
+        TLSClientConfig: tlsConfig,
+        QUICConfig: &quic.Config{
+            HandshakeIdleTimeout: 10 * time.Second,
+            KeepAlivePeriod: ct.KeepAlive,
+        },
+        Dial: dialQUIC,
+    }
+}
+
+// Dial a QUIC connection, the handshake is recorded to the trace of the request when it completes.
+// The connection is returned early, so requests can be sent in 0-RTT data before that.
+func dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+    trace := requestTraceOf(ctx)
+    trace.quicDial()
+    start := time.Now()
+    conn, err := quic.DialAddrEarly(ctx, addr, tlsConfig, config)
+    if err != nil {
+        trace.quicHandshakeDone(0, false)
+        return nil, err
+    }
+    go func() {
+        select {
+        case <-conn.HandshakeComplete():
+            trace.quicHandshakeDone(time.Since(start), conn.ConnectionState().Used0RTT)
+        case <-conn.Context().Done():
+            trace.quicHandshakeDone(0, false)
+        }
+    }()
+    return conn, nil
+}
+
+// Make the request be sent in 0-RTT data if the connection is resumed, only for idempotent GET and HEAD.
+func http3EarlyRequest(req *http.Request) *http.Request {
+    switch req.Method {
+    case http.MethodGet:
+        req.Method = http3.MethodGet0RTT
+    case http.MethodHead:
+        req.Method = http3.MethodHead0RTT
+    }
+    return req
+}
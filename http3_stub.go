@@ -0,0 +1,17 @@
+//go:build !http3
+
+package main
+
+import "net/http"
+
+// Whether boom is built with HTTP/3 support
+const http3Supported = false
+
+// HTTP/3 needs quic-go, boom is built with it by the http3 tag. checkOpts refuses -http3 without it.
+func newHTTP3Transport(ct *CtrlCenter) http.RoundTripper {
+    return http.DefaultTransport
+}
+
+func http3EarlyRequest(req *http.Request) *http.Request {
+    return req
+}
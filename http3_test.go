@@ -0,0 +1,89 @@
+//go:build http3
+
+package main
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/quic-go/quic-go"
+    "github.com/quic-go/quic-go/http3"
+)
+
+// Start a local HTTP/3 server accepting 0-RTT, it answers the method, path and X-Boom header of requests.
+func startHTTP3Server(t *testing.T) string {
+    // The certificate of httptest, it's not verified by boom
+    tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+    cert := tlsServer.TLS.Certificates[0]
+    tlsServer.Close()
+
+    conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := &http3.Server{
+        TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+        QUICConfig: &quic.Config{Allow0RTT: true},
+        Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, r.Header.Get("X-Boom"))
+        }),
+    }
+    go server.Serve(conn)
+    t.Cleanup(func() {
+        server.Close()
+        conn.Close()
+    })
+    return "https://" + conn.LocalAddr().String()
+}
+
+func TestHTTP3Engine(t *testing.T) {
+    url := startHTTP3Server(t)
+    ct := NewDefaultCtrlCenter()
+    ct.Http3Enable = true
+    ct.Allow0RTT = true
+    ct.Timeout = 5 * time.Second
+    ct.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+    e := newHTTPEngine(ct, nil)
+    ct.Expect.BodyContains = "GET /items boom"
+
+    hit := func() *Damage {
+        req, err := http.NewRequest(http.MethodGet, url + "/items", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        req.Header.Set("X-Boom", "boom")
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.Error != "" {
+            t.Fatalf("request error: %s", damage.Error)
+        }
+        return damage
+    }
+
+    // A new connection: the QUIC handshake is timed, 0-RTT is not possible without a session
+    first := hit()
+    if first.Proto != "HTTP/3.0" || first.StatusCode != http.StatusOK {
+        t.Errorf("first response = %s %d", first.Proto, first.StatusCode)
+    }
+    if first.ConnReused || first.TLSHandshake <= 0 || first.Used0RTT {
+        t.Errorf("first request: reused %v, handshake %v, 0-RTT %v", first.ConnReused, first.TLSHandshake, first.Used0RTT)
+    }
+
+    // The same connection
+    second := hit()
+    if !second.ConnReused || second.TLSHandshake != 0 {
+        t.Errorf("second request: reused %v, handshake %v", second.ConnReused, second.TLSHandshake)
+    }
+
+    // A new connection resumes the session, the request is sent in 0-RTT data
+    e.client.CloseIdleConnections()
+    resumed := hit()
+    if resumed.ConnReused || !resumed.Used0RTT {
+        t.Errorf("resumed request: reused %v, 0-RTT %v", resumed.ConnReused, resumed.Used0RTT)
+    }
+}
@@ -34,30 +34,121 @@ func parseArgs() *BoomOptions {
         RequestGoroutines:100,
         RequestDuration: 1 * time.Second,
         EnableKeepAlive:false,
+        ShowProgress:true,
+        TimeSeriesInterval: time.Second,
         RequestTimeout: 30 * time.Second,
     }
+    flag.BoolVar(&boomOpts.Allow0RTT, "0rtt", false, "With -http3, send GET and HEAD requests in 0-RTT data when " +
+        "QUIC connections are resumed.")
+    flag.Var(&boomOpts.Assertions, "assert", "Threshold checked against the final report, boom exits with 1 if it's " +
+        "not met. Repeatable. eg. -assert 'p99<250ms' -assert 'success_rate>0.999' -assert 'rps>=900'. Metrics: " +
+        "min, mean, max, stddev, p50, p75, p90, p95, p99, p99.9 compared with durations; rps, success_rate, " +
+        "error_rate, requests, failed_requests compared with numbers.")
     flag.StringVar(&boomOpts.Authentication, "A", "", "Supply BASIC Authentication credentials to the server. " +
         "The username and password are separated by a single : .")
-    flag.StringVar(&boomOpts.RequestCookies, "C", "", "Add a Cookie: line to the request like: cookie-name=value")
+    flag.StringVar(&boomOpts.RequestCookies, "C", "", "Add a Cookie: line to the request like: " +
+        "cookie-name=value. Separate multiple cookies by ; eg. a=1;b=2")
+    flag.BoolVar(&boomOpts.ClosedLoop, "closed", false, "Closed loop: -g virtual users, each sends a request, " +
+        "waits for the response and the -think time, then sends the next one. Runs for -t, or -iterations " +
+        "requests of each user.")
     flag.IntVar(&cpuToUse, "cpu", 1, "The cpu to use when sending requests")
     flag.StringVar(&boomOpts.RequestPostDataContentType, "c", "", "Content-type header to use for POST/PUT data, " +
         "eg. application/x-www-form-urlencoded. Default is text/plain.")
     flag.StringVar(&boomOpts.RequestPostData, "D", "", "File or just a string containing data to POST. Remember to " +
         "also set -c." + "When using a file for input, remember add '@@' prefix to the file path. eg. @@/home/work/a.json")
+    flag.StringVar(&boomOpts.ExpectBody, "expect-body", "", "Text the response body must contain to be a success.")
+    flag.StringVar(&boomOpts.ExpectBodyRegex, "expect-body-regex", "", "Regex the response body must match to be " +
+        "a success.")
+    flag.StringVar(&boomOpts.ExpectHeader, "expect-header", "", "Header the response must have to be a success, " +
+        "like: name or name:value")
+    flag.StringVar(&boomOpts.ExpectJSON, "expect-json", "", "Assertion on the JSON response body to be a success, " +
+        "like: $.status == \"ok\" or $.items[0].id. Without == or !=, the value must exist.")
+    flag.StringVar(&boomOpts.DumpFile, "dump", "", "Stream every request result to the file in JSON Lines " +
+        "format, one JSON object per line. eg. results.jsonl")
+    flag.StringVar(&boomOpts.FeederMode, "feeder-mode", feedCircular, "How to pick rows of the CSV files used " +
+        "by {{csv}} in templates(-templates): sequential, circular or random.")
+    flag.StringVar(&boomOpts.FindMax, "find-max", "", "Search the highest rate meeting the criteria instead of a " +
+        "single test. The criteria are assertions like -assert separated by commas. Each rate is probed for -t, " +
+        "starting from -r. eg. -find-max 'p99<250ms,error_rate<0.01' -t 10s")
     flag.IntVar(&boomOpts.RequestGoroutines, "g", 100, " Number of threads(goroutines) to perform for the test.")
+    flag.BoolVar(&boomOpts.GRPCEnable, "grpc", false, "Call unary gRPC methods instead of HTTP. URLs are like " +
+        "grpc://host:port/package.Service/Method, grpcs:// for TLS. The body(-D) is the JSON of the request " +
+        "message, headers(-H) are sent as metadata. Boom must be built with gRPC support: go build -tags grpc")
+    flag.StringVar(&boomOpts.GRPCProtoset, "grpc-protoset", "", "With -grpc, the descriptor set of the methods made " +
+        "by protoc --include_imports -o FILE. Server reflection is used if it's not set.")
+    flag.BoolVar(&boomOpts.StrictMaxStreams, "h2-strict-streams", false, "With -http2, never open more connections " +
+        "when the streams of the open ones reach the server's limit, requests wait for a free stream instead.")
+    flag.BoolVar(&boomOpts.Http2Enable, "http2", false, "Send requests over HTTP/2 only: negotiated by ALPN for " +
+        "https, and with prior knowledge(h2c) for http URLs.")
+    flag.BoolVar(&boomOpts.Http3Enable, "http3", false, "Send requests over HTTP/3(QUIC). Boom must be built with " +
+        "HTTP/3 support: go build -tags http3")
     flag.StringVar(&boomOpts.RequestHeaders, "H", "", "Append extra headers to the request like: head-type:value")
+    flag.DurationVar(&boomOpts.TimeSeriesInterval, "interval", time.Second, "Interval of the rows in the time " +
+        "series of the report. Each row holds the requests sent in the interval. 0 to disable.")
+    flag.IntVar(&boomOpts.Iterations, "iterations", 0, "Requests each virtual user sends in closed loop, for -t " +
+        "if it's 0.")
     flag.BoolVar(&boomOpts.EnableKeepAlive, "k", false, "Enable the HTTP KeepAlive feature")
     flag.BoolVar(&showLogs, "l", false, "Enable log output")
-    flag.StringVar(&boomOpts.LocalAddr, "la", "", "Local address  to bind to when making outgoing connections.")
+    flag.StringVar(&boomOpts.LocalAddr, "la", "", "Local address to bind to when making outgoing " +
+        "connections. Separate multiple addresses by commas, connections rotate across them. eg. 10.0.0.1,10.0.0.2")
     flag.StringVar(&boomOpts.RequestMethod, "m", "GET", "Custom HTTP method for the requests.")
+    flag.IntVar(&boomOpts.MaxConnsPerHost, "max-conns", 0, "Most connections to each host, requests wait for " +
+        "a free one when they are all busy. With -http2, requests share connections up to the streams limit of " +
+        "the server. 0 for no limit.")
+    flag.IntVar(&boomOpts.MaxWorkers, "max-workers", defaultMaxWorkers, "Most warheads(goroutines) in open loop. " +
+        "More are started when all are busy, up to this limit. 0 for no limit.")
+    flag.StringVar(&boomOpts.Overflow, "overflow", overflowQueue, "What to do with a request in open loop when " +
+        "-max-workers warheads are busy: drop it, or queue it until one is free, then it's sent late.")
     flag.IntVar(&boomOpts.TotalRequests, "n", 0, "Number of requests to perform for the test. If this flag > 0, the " +
         "-t and -r will be ignore.")
+    flag.StringVar(&boomOpts.ThinkTime, "think", "", "Think time of virtual users in closed loop, between a " +
+        "response and the next request: a duration, uniform:MIN-MAX or exp:MEAN. eg. 500ms, uniform:100ms-1s, exp:500ms")
     flag.DurationVar(&boomOpts.RequestDuration, "t", time.Second, "Duration of this test.")
     flag.StringVar(&boomOpts.URL, "u", "", "The url to request")
+    flag.BoolVar(&boomOpts.SocketEnable, "socket", false, "Send the body(-D) as a payload over raw TCP or UDP " +
+        "instead of HTTP requests. URLs are like tcp://host:port or udp://host:port. A response is complete when it " +
+        "matches -expect-body-regex or contains -expect-body, or when a read returns without them.")
+    flag.IntVar(&boomOpts.SocketConns, "socket-conns", 0, "With -socket, persistent TCP connections the requests " +
+        "are pipelined on, the responses are read in order. 0 for a connection each request.")
+    flag.BoolVar(&boomOpts.SocketHex, "socket-hex", false, "With -socket, the body is hex, whitespaces are " +
+        "ignored. eg. -D '00 01 ff'")
+    flag.BoolVar(&boomOpts.SocketNoReply, "socket-no-reply", false, "With -socket, don't wait for responses, " +
+        "the latency is the time to send. eg. syslog over UDP")
+    flag.DurationVar(&boomOpts.SocketReadTimeout, "socket-read-timeout", 0, "With -socket, how long to wait for " +
+        "a response, -s if it's 0.")
+    flag.BoolVar(&boomOpts.StreamBody, "stream-body", false, "Read the body file of -D @@file or targets " +
+        "from disk for every request instead of holding it in memory. Useful to upload big files.")
+    flag.StringVar(&boomOpts.SuccessCodes, "success-codes", "200-299", "Status codes of successful responses, " +
+        "separated by commas. eg. 200-299,302. Redirects are not followed if any 3xx code is accepted.")
+    flag.StringVar(&boomOpts.TimeSeriesOutput, "timeseries", "", "Output the time series of the report in " +
+        "specified CSV file, a row each interval. The percentiles of a row are estimated.")
+    flag.BoolVar(&boomOpts.WebSocketEnable, "ws", false, "Send the body(-D) as WebSocket messages over -g " +
+        "connections instead of HTTP requests, each one waits for its reply. URLs are like ws://host/path, wss:// " +
+        "for TLS. Headers(-H) are sent in the handshake.")
+    flag.StringVar(&boomOpts.WSCorrelate, "ws-correlate", "", "With -ws, JSON path of the correlation id in the " +
+        "messages, a reply is the message with the same id. eg. $.id. The reply is the echo of the message if it's " +
+        "not set.")
+    flag.StringVar(&boomOpts.TargetsFile, "targets", "", "File of targets to request instead of -u. Each target " +
+        "is a line of 'METHOD URL', then optional 'Header: value' lines and an optional '@/path/to/body' line.")
+    flag.StringVar(&boomOpts.TargetsOrder, "targets-order", orderRoundRobin, "Order to hit the targets: " +
+        "round-robin or random.")
+    flag.BoolVar(&boomOpts.Templates, "templates", false, "Render the url, header values and body of targets as " +
+        "templates for every request, eg. {{uuid}}. Without it, {{ is sent as it is.")
     flag.StringVar(&boomOpts.ResultOutput, "o", "Stdout", "Output the reports in specified location")
-    flag.DurationVar(&boomOpts.RequestTimeout, "s", 30 * time.Second, "Maximum number of seconds to wait before a " +
-        "request times out.")
+    flag.StringVar(&boomOpts.ResultFormat, "format", "", "Format of the report file: json, csv or text. " +
+        "Picked by the extension of -o if not set.")
+    flag.DurationVar(&boomOpts.RequestTimeout, "s", 30 * time.Second, "How long to wait for a response before a " +
+        "request times out. Over HTTP it bounds waiting for the response headers, so a long upload or download " +
+        "isn't cut by it. Over gRPC, WebSocket and sockets it bounds the whole round trip.")
     flag.IntVar(&boomOpts.RequestPerSec, "r", 50, "Number of requests to perform at one sec.")
+    flag.StringVar(&boomOpts.LoadProfile, "profile", "", "Shape of the rate instead of the constant -r, lasts -t: " +
+        "ramp:FROM-TO, step:START,STEP,EVERY, sine:MEAN,AMPLITUDE,PERIOD, spike:BASE,SPIKE,EVERY,LENGTH, or " +
+        "@FILE of stages, a line of 'DURATION RPS' or 'DURATION FROM-TO' each. eg. ramp:10-500, step:100,50,10s")
+    flag.BoolVar(&boomOpts.ShowProgress, "progress", true, "Show the live status every second on stderr during " +
+        "the test: elapsed time, requests per second, in-flight requests, error rate and latencies. The status line " +
+        "is redrawn in place on a terminal, a plain line is printed each time otherwise.")
+    flag.BoolVar(&boomOpts.RawSamples, "raw", false, "Keep every request result in memory. The latencies are " +
+        "exact and the JSON report holds all the results, but the memory grows with the test.")
     flag.BoolVar(&showVersion, "V", false, " Show version of boom then exit")
     flag.Parse()
 
@@ -110,5 +201,7 @@ func main() {
     // start boom
     boomStartTime = time.Now()
     welcome()
-    Boom(boomOpts)
+    if err := Boom(boomOpts); err != nil {
+        os.Exit(1)
+    }
 }
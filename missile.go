@@ -1,13 +1,13 @@
 package main
 
 import (
+    "context"
     "net"
-    "net/http"
     "time"
     "crypto/tls"
     "sync"
-    "io"
-    "io/ioutil"
+    "sync/atomic"
+    "strings"
     "log"
 )
 
@@ -15,25 +15,45 @@ const (
     defaultTimeout = 30 * time.Second
     defaultMaxIdleConnections = 100
     defaultWarheads = 100
+    defaultMaxWorkers = 10000
+    lateTickThreshold = 10 * time.Millisecond // A tick is late if it's sent later than this after its intended time
     noFollow = -1
 )
-// Missile is a wrapper of http.Client and some properties
+
+// What to do with a tick in open loop when all the workers are busy and no more can be started
+const (
+    overflowDrop = "drop"   // Skip the tick
+    overflowQueue = "queue" // Wait for a free worker, the tick is sent late
+)
+// Missile is a wrapper of the protocol engine and some properties, the engine is HTTP by default.
 // A missile can carry many warheads means multi goroutines
 type Missile struct {
-    ctrl   *CtrlCenter
-    dialer *net.Dialer
-    client http.Client
+    ctrl       *CtrlCenter
+    dialers    []*net.Dialer // One dialer for each local address
+    nextDialer uint64
+    engine     Engine
+    inFlight   int64 // Requests sent but not yet done
+    dropped    int64 // Ticks skipped in open loop since launched
+    late       int64 // Ticks sent late in open loop since launched
 }
 
 type CtrlCenter struct {
     Timeout            time.Duration
     Warheads           int // How many warhead can this missile carry
+    MaxWorkers         int    // Most warheads started in open loop, no limit if it's not positive
+    Overflow           string // What to do with a tick when there are MaxWorkers warheads busy, drop or queue
     MaxIdleConnections int
     KeepAlive          time.Duration
-    Http2Enable        bool
-    MaxRedirects       int
+    Http2Enable        bool // Send requests over HTTP/2 only, h2c for http:// URLs
+    MaxConnsPerHost    int  // Most connections to a host, requests wait for one if they are all busy. No limit if 0
+    Http3Enable        bool // Send requests over HTTP/3(QUIC), boom must be built with the http3 tag
+    Allow0RTT          bool // Send requests in 0-RTT data when resuming QUIC connections
+    StrictMaxStreams   bool // Never open a new HTTP/2 connection when the streams of the others reach the server's limit
+    MaxRedirects       int // Most redirects followed, 0 for the default of http.Client, noFollow for none
     LocalAddr          *net.IPAddr
+    LocalAddrs         []*net.IPAddr // Connections rotate across these local addresses, overrides LocalAddr
     TLSConfig          *tls.Config
+    Expect             *Expectation // What a successful response is
     Cancel             chan struct{}
 }
 
@@ -48,10 +68,13 @@ func NewDefaultCtrlCenter() *CtrlCenter {
     c.Timeout = defaultTimeout
     c.MaxIdleConnections = defaultMaxIdleConnections
     c.Warheads = defaultWarheads
+    c.MaxWorkers = defaultMaxWorkers
+    c.Overflow = overflowQueue
     c.KeepAlive = 0
     c.Http2Enable = false
     c.LocalAddr = defaultLocalAddr
     c.TLSConfig = defaultTLSConfig
+    c.Expect = NewExpectation()
     c.Cancel = make(chan struct{})
     return c;
 }
@@ -67,91 +90,183 @@ func NewCustomMissile(ct *CtrlCenter) *Missile {
     if ct == nil {
         ct = NewDefaultCtrlCenter()
     }
+    if ct.Expect == nil {
+        ct.Expect = NewExpectation()
+    }
     missile.ctrl = ct
-    missile.dialer = &net.Dialer{
-        LocalAddr:  &net.TCPAddr{IP: defaultLocalAddr.IP, Zone: defaultLocalAddr.Zone},
-        KeepAlive: ct.KeepAlive,
-        Timeout:   ct.Timeout,
+    localAddrs := ct.LocalAddrs
+    if len(localAddrs) == 0 {
+        localAddrs = []*net.IPAddr{ct.LocalAddr}
     }
-
-    missile.client = http.Client{
-        Transport: &http.Transport{
-            Proxy: http.ProxyFromEnvironment,
-            Dial:  missile.dialer.Dial,
-            ResponseHeaderTimeout: defaultTimeout,
-            TLSClientConfig:       defaultTLSConfig,
-            TLSHandshakeTimeout:   10 * time.Second,
-            MaxIdleConnsPerHost:   ct.MaxIdleConnections,
-        },
+    for _, addr := range localAddrs {
+        dialer := &net.Dialer{
+            KeepAlive: ct.KeepAlive,
+            Timeout:   ct.Timeout,
+        }
+        // Let the system choose if the address is 0.0.0.0 or ::
+        if addr != nil && !addr.IP.IsUnspecified() {
+            dialer.LocalAddr = &net.TCPAddr{IP: addr.IP, Zone: addr.Zone}
+        }
+        missile.dialers = append(missile.dialers, dialer)
     }
+
+    missile.engine = newHTTPEngine(ct, missile.dial)
     return missile
 }
 
-// Launch the Missile
-func (missile *Missile) Launch(target *Target, totalHits int, hitPerSecond int, du time.Duration) <-chan *Damage {
+// Arm the missile with another protocol engine instead of HTTP
+func (missile *Missile) Arm(engine Engine) {
+    missile.engine = engine
+}
+
+// Dial a new connection, the local addresses are used in turn.
+// So the connections from a single box are not limited by the ports of one address.
+func (missile *Missile) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+    n := atomic.AddUint64(&missile.nextDialer, 1) - 1
+    dialer := missile.dialers[n % uint64(len(missile.dialers))]
+    // The local address must be of the same network
+    if local, ok := dialer.LocalAddr.(*net.TCPAddr); ok && strings.HasPrefix(network, "udp") {
+        udpDialer := *dialer
+        udpDialer.LocalAddr = &net.UDPAddr{IP: local.IP, Zone: local.Zone}
+        return udpDialer.DialContext(ctx, network, addr)
+    }
+    return dialer.DialContext(ctx, network, addr)
+}
+
+// Launch the Missile, sends totalHits requests if it's positive, otherwise follows the load profile.
+func (missile *Missile) Launch(targets *Targets, totalHits int, profile LoadProfile) <-chan *Damage {
 
     var warheadsWaitGroup sync.WaitGroup
     damagesCh := make(chan *Damage)
     fireCmdCh := make(chan time.Time)
     log.Println("Fireing...")
+    atomic.StoreInt64(&missile.dropped, 0)
+    atomic.StoreInt64(&missile.late, 0)
     // Each warhead standard for a single goroutine
-    for i := 0; i < missile.ctrl.Warheads; i++ {
+    workers, maxWorkers := missile.ctrl.Warheads, missile.ctrl.MaxWorkers
+    if maxWorkers > 0 && totalHits <= 0 && workers > maxWorkers {
+        workers = maxWorkers
+    }
+    for i := 0; i < workers; i++ {
         warheadsWaitGroup.Add(1)
-        go missile.fire(target, &warheadsWaitGroup, fireCmdCh, damagesCh)
+        go missile.fire(targets, &warheadsWaitGroup, fireCmdCh, damagesCh)
     }
     go func() {
         defer close(damagesCh)
         defer warheadsWaitGroup.Wait()
         defer close(fireCmdCh)
         if totalHits > 0 {
-            done := 0
-            for {
-                //time.Sleep(1 * time.Second)
-                //time.Sleep(1 * time.Nanosecond)
+            // As fast as the warheads can, at most one request in flight for each of them
+            for done := 0; done < totalHits; done++ {
                 select {
                 case fireCmdCh <- time.Now():
-                    if done++; done == totalHits {
-                        return
-                    }
                 case <-missile.ctrl.Cancel:
                     return
-                default:
-                // all warhead are blocked. start one more and try again
-                    warheadsWaitGroup.Add(1)
-                    go missile.fire(target, &warheadsWaitGroup, fireCmdCh, damagesCh)
                 }
             }
         } else {
-            //Interval non-negative nanosecond
-            interval := 1e9 / hitPerSecond
-            hitsSum := hitPerSecond * int(du.Seconds())
-            began, done := time.Now(), 0
+            // Open loop: requests are intended to be sent at the rate of the profile, however long the previous ones take.
+            // A late request keeps its intended time as the tick, so the latency from it counts the delay as well.
+            ticker := newProfileTicker(profile)
+            began := time.Now()
             for {
-                now, next := time.Now(), began.Add(time.Duration(done * interval))
-                time.Sleep(next.Sub(now))
+                elapsed, ok := ticker.next()
+                if !ok {
+                    return
+                }
+                next := began.Add(elapsed)
+                if !missile.waitUntil(next) {
+                    return
+                }
                 select {
-                case fireCmdCh <- max(next, now):
-                    if done++; done == hitsSum {
+                case fireCmdCh <- next:
+                default:
+                    // all workers are blocked. start one more to send it, unless there are too many
+                    if maxWorkers <= 0 || workers < maxWorkers {
+                        workers++
+                        warheadsWaitGroup.Add(1)
+                        go missile.fire(targets, &warheadsWaitGroup, fireCmdCh, damagesCh)
+                    } else if missile.ctrl.Overflow == overflowDrop {
+                        atomic.AddInt64(&missile.dropped, 1)
+                        continue
+                    }
+                    select {
+                    case fireCmdCh <- next:
+                    case <-missile.ctrl.Cancel:
                         return
                     }
+                }
+                if time.Since(next) > lateTickThreshold {
+                    atomic.AddInt64(&missile.late, 1)
+                }
+            }
+        }
+    }()
+    return damagesCh
+}
+
+// Launch the Missile in closed loop, each warhead is a virtual user: it sends a request, waits for the response,
+// thinks, then sends the next one. So exactly as many requests as warheads are in flight at most.
+// Each user sends iterations requests if it's positive, otherwise it keeps sending for the duration.
+func (missile *Missile) LaunchUsers(targets *Targets, iterations int, du time.Duration, think *ThinkTime) <-chan *Damage {
+
+    var usersWaitGroup sync.WaitGroup
+    damagesCh := make(chan *Damage)
+    deadline := time.Now().Add(du)
+    log.Println("Fireing...")
+    for i := 0; i < missile.ctrl.Warheads; i++ {
+        usersWaitGroup.Add(1)
+        go func() {
+            defer usersWaitGroup.Done()
+            for n := 0; iterations <= 0 || n < iterations; n++ {
+                if iterations <= 0 && !time.Now().Before(deadline) {
+                    return
+                }
+                select {
                 case <-missile.ctrl.Cancel:
                     return
                 default:
-                // all workers are blocked. start one more and try again
-                    warheadsWaitGroup.Add(1)
-                    go missile.fire(target, &warheadsWaitGroup, fireCmdCh, damagesCh)
+                }
+                damagesCh <- missile.hit(targets.Next(), time.Now())
+                if d := think.next(); d > 0 && !missile.waitUntil(time.Now().Add(d)) {
+                    return
                 }
             }
-        }
+        }()
+    }
+    go func() {
+        usersWaitGroup.Wait()
+        close(damagesCh)
     }()
     return damagesCh
 }
 
-func (missile *Missile) fire(target *Target, warheadsWaitGroup *sync.WaitGroup, fireCmdCh <-chan time.Time, results chan <-*Damage) {
+// Wait until t, returns false if the attack is stopped meanwhile
+func (missile *Missile) waitUntil(t time.Time) bool {
+    d := time.Until(t)
+    if d <= 0 {
+        select {
+        case <-missile.ctrl.Cancel:
+            return false
+        default:
+            return true
+        }
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return true
+    case <-missile.ctrl.Cancel:
+        return false
+    }
+}
+
+func (missile *Missile) fire(targets *Targets, warheadsWaitGroup *sync.WaitGroup, fireCmdCh <-chan time.Time, results chan <-*Damage) {
 
     defer warheadsWaitGroup.Done()
     for fc := range fireCmdCh {
-        results <- missile.hit(target, fc)
+        results <- missile.hit(targets.Next(), fc)
     }
 
 }
@@ -159,44 +274,29 @@ func (missile *Missile) fire(target *Target, warheadsWaitGroup *sync.WaitGroup,
 // Hit the Target
 func (missile *Missile) hit(target *Target, fireCmdTime time.Time) *Damage {
 
-    damage := &Damage{Timestamp: fireCmdTime}
+    atomic.AddInt64(&missile.inFlight, 1)
+    defer atomic.AddInt64(&missile.inFlight, -1)
+
+    damage := &Damage{Target: target.Name(), Timestamp: fireCmdTime}
     req, err := target.Request()
     if err != nil {
+        damage.setError(err)
         return damage
     }
+    damage.Method, damage.URL = req.Method, req.URL.String()
 
-    damage.StartTime = time.Now()
-    // Do http request
-    resp, err := missile.client.Do(req)
-
-    // Calculate the latency
-    damage.EndTime = time.Now()
-    damage.Latency = damage.EndTime.Sub(damage.StartTime)
-
-    if err != nil {
-        damage.Error = err.Error()
-        return damage
-    }
-    defer resp.Body.Close()
+    missile.engine.Hit(req, damage)
+    return damage
+}
 
-    // Just discard the response body
-    in, err := io.Copy(ioutil.Discard, resp.Body)
-    if err != nil {
-        damage.Error = err.Error()
-        return damage
-    }
-    // Calculate the bytes received
-    damage.ReceivedBytes = uint64(in)
+// How many requests are sent but not yet done
+func (missile *Missile) InFlight() int64 {
+    return atomic.LoadInt64(&missile.inFlight)
+}
 
-    // Calculate the bytes sent
-    if req.ContentLength != -1 {
-        damage.SentBytes = uint64(req.ContentLength)
-    }
-    // Calculate the err info
-    if damage.StatusCode = resp.StatusCode; damage.StatusCode != 200 {
-        damage.Error = resp.Status
-    }
-    return damage
+// How many ticks are skipped and sent late in open loop since launched
+func (missile *Missile) OverflowTicks() (dropped int64, late int64) {
+    return atomic.LoadInt64(&missile.dropped), atomic.LoadInt64(&missile.late)
 }
 
 // Stop stops the current attack.
@@ -210,11 +310,3 @@ func (missile *Missile) Stop() {
     }
 
 }
-
-// Judge which time is later
-func max(a, b time.Time) time.Time {
-    if a.After(b) {
-        return a
-    }
-    return b
-}
\ No newline at end of file
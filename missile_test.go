@@ -0,0 +1,247 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// A server answering after the delay, it tracks the most requests in flight at once
+type delayServer struct {
+    *httptest.Server
+    inFlight    int64
+    maxInFlight int64
+    hits        int64
+}
+
+func startDelayServer(t *testing.T, delay time.Duration) *delayServer {
+    s := &delayServer{}
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt64(&s.hits, 1)
+        n := atomic.AddInt64(&s.inFlight, 1)
+        defer atomic.AddInt64(&s.inFlight, -1)
+        for {
+            max := atomic.LoadInt64(&s.maxInFlight)
+            if n <= max || atomic.CompareAndSwapInt64(&s.maxInFlight, max, n) {
+                break
+            }
+        }
+        time.Sleep(delay)
+    }))
+    t.Cleanup(s.Close)
+    return s
+}
+
+func testTargets(t *testing.T, url string) *Targets {
+    targets, err := NewTargets([]*Target{NewTarget(url)}, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return targets
+}
+
+// All the damages until the channel is closed
+func collectDamages(damages <-chan *Damage) []*Damage {
+    var all []*Damage
+    for damage := range damages {
+        all = append(all, damage)
+    }
+    return all
+}
+
+// The ticks of the rate in open loop, -t below a second as well
+func TestLaunchTicks(t *testing.T) {
+    server := startDelayServer(t, 0)
+    tests := []struct {
+        rate     float64
+        duration time.Duration
+        hits     int
+    }{
+        {200, 500 * time.Millisecond, 100},
+        {50, 300 * time.Millisecond, 15},
+        {10, 100 * time.Millisecond, 1},
+        {20, time.Second, 20},
+    }
+    for _, tt := range tests {
+        ct := NewDefaultCtrlCenter()
+        ct.Warheads = 10
+        missile := NewCustomMissile(ct)
+        start := time.Now()
+        damages := collectDamages(missile.Launch(testTargets(t, server.URL), 0, &constantProfile{tt.rate, tt.duration}))
+        elapsed := time.Since(start)
+        if len(damages) != tt.hits {
+            t.Errorf("rate %.0f for %v: %d hits, want %d", tt.rate, tt.duration, len(damages), tt.hits)
+        }
+        if elapsed > tt.duration + 200 * time.Millisecond {
+            t.Errorf("rate %.0f for %v: took %v", tt.rate, tt.duration, elapsed)
+        }
+        for i, damage := range damages {
+            if damage.Error != "" {
+                t.Errorf("rate %.0f for %v: hit %d: %s", tt.rate, tt.duration, i, damage.Error)
+            }
+        }
+    }
+}
+
+// With -n, exactly n requests as fast as the warheads can
+func TestLaunchTotalHits(t *testing.T) {
+    server := startDelayServer(t, 10 * time.Millisecond)
+    ct := NewDefaultCtrlCenter()
+    ct.Warheads = 3
+    damages := collectDamages(NewCustomMissile(ct).Launch(testTargets(t, server.URL), 12, nil))
+    if len(damages) != 12 || server.maxInFlight > 3 {
+        t.Errorf("%d hits, %d in flight at most, want 12 and 3", len(damages), server.maxInFlight)
+    }
+}
+
+// A slow server delays the requests queued for the only worker, the corrected latency counts the delay
+// from the intended time, the latency doesn't. With workers started as needed, they are the same.
+func TestLaunchCorrectedLatency(t *testing.T) {
+    server := startDelayServer(t, 50 * time.Millisecond)
+    tests := []struct {
+        name       string
+        maxWorkers int
+        delayed    bool
+    }{
+        {"queued", 1, true},
+        {"workers started", 0, false},
+    }
+    for _, tt := range tests {
+        ct := NewDefaultCtrlCenter()
+        ct.Warheads = 1
+        ct.MaxWorkers = tt.maxWorkers
+        ct.Overflow = overflowQueue
+        missile := NewCustomMissile(ct)
+        damages := collectDamages(missile.Launch(testTargets(t, server.URL), 0, &constantProfile{50, 200 * time.Millisecond}))
+        if len(damages) != 10 {
+            t.Fatalf("%s: %d hits, want 10", tt.name, len(damages))
+        }
+        var maxLatency, maxCorrected time.Duration
+        for _, damage := range damages {
+            if damage.Latency > maxLatency {
+                maxLatency = damage.Latency
+            }
+            if d := damage.correctedLatency(); d > maxCorrected {
+                maxCorrected = d
+            }
+            if damage.correctedLatency() < damage.Latency {
+                t.Errorf("%s: corrected latency %v is less than the latency %v", tt.name, damage.correctedLatency(),
+                    damage.Latency)
+            }
+        }
+        _, late := missile.OverflowTicks()
+        if delayed := maxCorrected > 250 * time.Millisecond; delayed != tt.delayed || (late > 0) != tt.delayed {
+            t.Errorf("%s: latency %v at most, corrected %v at most, %d late ticks", tt.name, maxLatency, maxCorrected,
+                late)
+        }
+        if maxLatency > 150 * time.Millisecond {
+            t.Errorf("%s: latency %v counts the delay before sending", tt.name, maxLatency)
+        }
+    }
+}
+
+func TestDamageCorrectedLatency(t *testing.T) {
+    start := time.Now()
+    tests := []struct {
+        damage *Damage
+        want   time.Duration
+    }{
+        {&Damage{Timestamp: start, StartTime: start.Add(30), EndTime: start.Add(100), Latency: 70}, 100},
+        {&Damage{Timestamp: start, StartTime: start, EndTime: start.Add(40), Latency: 40}, 40},
+        // Failed before sending, or no intended time
+        {&Damage{Timestamp: start, Latency: 5}, 5},
+        {&Damage{EndTime: start, Latency: 5}, 5},
+    }
+    for i, tt := range tests {
+        if got := tt.damage.correctedLatency(); got != tt.want {
+            t.Errorf("damage %d: corrected latency %v, want %v", i, got, tt.want)
+        }
+    }
+}
+
+// With all of -max-workers busy on a slow server, ticks are skipped in drop mode, and sent late in queue mode
+func TestLaunchOverflow(t *testing.T) {
+    tests := []struct {
+        overflow string
+        delay    time.Duration
+        minSent  int
+        maxSent  int
+    }{
+        // 4 workers busy for 300ms each, 100 ticks a second for 500ms: 4 sent at once, then 4 more
+        {overflowDrop, 300 * time.Millisecond, 6, 10},
+        {overflowQueue, 100 * time.Millisecond, 50, 50},
+    }
+    for _, tt := range tests {
+        server := startDelayServer(t, tt.delay)
+        ct := NewDefaultCtrlCenter()
+        ct.Warheads = 4
+        ct.MaxWorkers = 4
+        ct.Overflow = tt.overflow
+        missile := NewCustomMissile(ct)
+        damages := collectDamages(missile.Launch(testTargets(t, server.URL), 0, &constantProfile{100, 500 * time.Millisecond}))
+        dropped, late := missile.OverflowTicks()
+        sent := len(damages)
+        if sent < tt.minSent || sent > tt.maxSent || sent + int(dropped) != 50 {
+            t.Errorf("%s: %d sent, %d dropped, want %d to %d sent of 50", tt.overflow, sent, dropped, tt.minSent,
+                tt.maxSent)
+        }
+        if server.maxInFlight > 4 {
+            t.Errorf("%s: %d requests in flight at most, want 4", tt.overflow, server.maxInFlight)
+        }
+        // Only the queued ticks are late
+        if tt.overflow == overflowQueue && late < 40 || tt.overflow == overflowDrop && late > 4 {
+            t.Errorf("%s: %d ticks late", tt.overflow, late)
+        }
+    }
+}
+
+// Each user has a request in flight at most, and sends iterations requests
+func TestLaunchUsersIterations(t *testing.T) {
+    tests := []struct {
+        users      int
+        iterations int
+        think      *ThinkTime
+    }{
+        {1, 5, nil},
+        {5, 4, nil},
+        {8, 3, &ThinkTime{kind: thinkFixed, min: 5 * time.Millisecond}},
+    }
+    for _, tt := range tests {
+        server := startDelayServer(t, 10 * time.Millisecond)
+        ct := NewDefaultCtrlCenter()
+        ct.Warheads = tt.users
+        damages := collectDamages(NewCustomMissile(ct).LaunchUsers(testTargets(t, server.URL), tt.iterations, time.Minute,
+            tt.think))
+        if want := tt.users * tt.iterations; len(damages) != want || int(server.hits) != want {
+            t.Errorf("%d users, %d iterations: %d damages, %d hits, want %d", tt.users, tt.iterations, len(damages),
+                server.hits, want)
+        }
+        if server.maxInFlight > int64(tt.users) {
+            t.Errorf("%d users: %d requests in flight at most", tt.users, server.maxInFlight)
+        }
+    }
+}
+
+// Without iterations, the users stop at the deadline of -t
+func TestLaunchUsersDuration(t *testing.T) {
+    server := startDelayServer(t, 20 * time.Millisecond)
+    ct := NewDefaultCtrlCenter()
+    ct.Warheads = 3
+    start := time.Now()
+    damages := collectDamages(NewCustomMissile(ct).LaunchUsers(testTargets(t, server.URL), 0, 300 * time.Millisecond, nil))
+    elapsed := time.Since(start)
+    if elapsed < 300 * time.Millisecond || elapsed > 450 * time.Millisecond {
+        t.Errorf("users stopped after %v, want 300ms", elapsed)
+    }
+    // About 15 requests each user
+    if len(damages) < 30 || len(damages) > 48 || server.maxInFlight > 3 {
+        t.Errorf("%d requests, %d in flight at most", len(damages), server.maxInFlight)
+    }
+    for _, damage := range damages {
+        if damage.Timestamp.Sub(start) >= 300 * time.Millisecond {
+            t.Errorf("request sent at %v, after the deadline", damage.Timestamp.Sub(start))
+        }
+    }
+}
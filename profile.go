@@ -0,0 +1,329 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// LoadProfile is the shape of the load in rate mode, it tells the planned rate at each moment of the test.
+type LoadProfile interface {
+    // Requests per second planned at the elapsed time since the test started
+    Rate(elapsed time.Duration) float64
+    // How long the test takes
+    Duration() time.Duration
+}
+
+// Longest step to integrate the planned rate, the rate is taken as constant within it.
+const profileStep = 10 * time.Millisecond
+
+// Create the load profile of -profile, a constant rate of -r if it's empty. Profiles other than a file last -t.
+//
+//     ramp:FROM-TO                   linear ramp from FROM to TO rps, eg. ramp:10-500
+//     step:START,STEP,EVERY          START rps, increased by STEP every EVERY, eg. step:100,50,10s
+//     sine:MEAN,AMPLITUDE,PERIOD     sine wave around MEAN rps, eg. sine:500,200,1m
+//     spike:BASE,SPIKE,EVERY,LENGTH  BASE rps, bursts of SPIKE rps lasting LENGTH every EVERY, eg. spike:100,1000,30s,5s
+//     @FILE                          stages listed in the file, see ReadStagesProfile
+func ParseLoadProfile(spec string, rate int, du time.Duration) (LoadProfile, error) {
+    if spec == "" {
+        return &constantProfile{float64(rate), du}, nil
+    }
+    if strings.HasPrefix(spec, "@") {
+        return ReadStagesProfile(spec[1:])
+    }
+    parts := strings.SplitN(spec, ":", 2)
+    if len(parts) != 2 {
+        return nil, fmt.Errorf("not valid load profile: %s", spec)
+    }
+    var args []string
+    if parts[0] == "ramp" {
+        args = strings.SplitN(parts[1], "-", 2)
+    } else {
+        args = strings.Split(parts[1], ",")
+    }
+    p := &profileArgs{spec: spec, args: args}
+    switch parts[0] {
+    case "ramp":
+        p.expect(2)
+        return p.check(&rampProfile{p.rate(0), p.rate(1), du})
+    case "step":
+        p.expect(3)
+        return p.check(&stepProfile{p.rate(0), p.float(1), p.duration(2), du})
+    case "sine":
+        p.expect(3)
+        return p.check(&sineProfile{p.rate(0), p.rate(1), p.duration(2), du})
+    case "spike":
+        p.expect(4)
+        return p.check(&spikeProfile{p.rate(0), p.rate(1), p.duration(2), p.duration(3), du})
+    }
+    return nil, fmt.Errorf("unknown load profile %s: %s", parts[0], spec)
+}
+
+// Arguments of a load profile, the first error is kept.
+type profileArgs struct {
+    spec string
+    args []string
+    err  error
+}
+
+func (p *profileArgs) expect(n int) {
+    if len(p.args) != n && p.err == nil {
+        p.err = fmt.Errorf("load profile %s expects %d arguments", p.spec, n)
+    }
+}
+
+func (p *profileArgs) float(i int) float64 {
+    if p.err != nil {
+        return 0
+    }
+    v, err := strconv.ParseFloat(strings.TrimSpace(p.args[i]), 64)
+    if err != nil {
+        p.err = fmt.Errorf("not valid number %s in load profile: %s", p.args[i], p.spec)
+    }
+    return v
+}
+
+func (p *profileArgs) rate(i int) float64 {
+    v := p.float(i)
+    if v < 0 && p.err == nil {
+        p.err = fmt.Errorf("negative rate %s in load profile: %s", p.args[i], p.spec)
+    }
+    return v
+}
+
+func (p *profileArgs) duration(i int) time.Duration {
+    if p.err != nil {
+        return 0
+    }
+    d, err := time.ParseDuration(strings.TrimSpace(p.args[i]))
+    if err != nil || d <= 0 {
+        p.err = fmt.Errorf("not valid duration %s in load profile: %s", p.args[i], p.spec)
+    }
+    return d
+}
+
+func (p *profileArgs) check(profile LoadProfile) (LoadProfile, error) {
+    if p.err != nil {
+        return nil, p.err
+    }
+    return profile, nil
+}
+
+// The same rate all the time
+type constantProfile struct {
+    rate     float64
+    duration time.Duration
+}
+
+func (p *constantProfile) Rate(elapsed time.Duration) float64 {
+    return p.rate
+}
+
+func (p *constantProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// Rate changes linearly from one to another
+type rampProfile struct {
+    from     float64
+    to       float64
+    duration time.Duration
+}
+
+func (p *rampProfile) Rate(elapsed time.Duration) float64 {
+    if p.duration <= 0 {
+        return p.to
+    }
+    return p.from + (p.to - p.from) * elapsed.Seconds() / p.duration.Seconds()
+}
+
+func (p *rampProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// Rate increases by a step every period
+type stepProfile struct {
+    start    float64
+    step     float64
+    every    time.Duration
+    duration time.Duration
+}
+
+func (p *stepProfile) Rate(elapsed time.Duration) float64 {
+    return math.Max(0, p.start + p.step * float64(elapsed / p.every))
+}
+
+func (p *stepProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// Rate goes up and down around the mean
+type sineProfile struct {
+    mean      float64
+    amplitude float64
+    period    time.Duration
+    duration  time.Duration
+}
+
+func (p *sineProfile) Rate(elapsed time.Duration) float64 {
+    return math.Max(0, p.mean + p.amplitude * math.Sin(2 * math.Pi * elapsed.Seconds() / p.period.Seconds()))
+}
+
+func (p *sineProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// Bursts of the spike rate start every period, the base rate in between
+type spikeProfile struct {
+    base     float64
+    spike    float64
+    every    time.Duration
+    length   time.Duration
+    duration time.Duration
+}
+
+func (p *spikeProfile) Rate(elapsed time.Duration) float64 {
+    if elapsed >= p.every && elapsed % p.every < p.length {
+        return p.spike
+    }
+    return p.base
+}
+
+func (p *spikeProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// A stage of the profile file, the rate changes linearly from the start to the end of the stage
+type profileStage struct {
+    duration time.Duration
+    from     float64
+    to       float64
+}
+
+// Stages one after another
+type stagesProfile struct {
+    stages   []profileStage
+    duration time.Duration
+}
+
+// Read the stages of a profile file. Each line is a stage of a duration and a rate, or a ramp of rates.
+// Blank lines and lines start with # are ignored. eg.
+//
+//     # warm up, hold, then ramp down
+//     30s 0-100
+//     5m  100
+//     30s 100-0
+func ReadStagesProfile(file string) (LoadProfile, error) {
+    in, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer in.Close()
+    p := &stagesProfile{}
+    scanner := bufio.NewScanner(in)
+    for n := 1; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("%s:%d: expect a duration and a rate: %s", file, n, line)
+        }
+        stage := profileStage{}
+        if stage.duration, err = time.ParseDuration(fields[0]); err != nil || stage.duration <= 0 {
+            return nil, fmt.Errorf("%s:%d: not valid duration: %s", file, n, fields[0])
+        }
+        rates := strings.SplitN(fields[1], "-", 2)
+        stage.from, err = strconv.ParseFloat(rates[0], 64)
+        stage.to = stage.from
+        if err == nil && len(rates) == 2 {
+            stage.to, err = strconv.ParseFloat(rates[1], 64)
+        }
+        if err != nil || stage.from < 0 || stage.to < 0 {
+            return nil, fmt.Errorf("%s:%d: not valid rate: %s", file, n, fields[1])
+        }
+        p.stages = append(p.stages, stage)
+        p.duration += stage.duration
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(p.stages) == 0 {
+        return nil, fmt.Errorf("no stages in %s", file)
+    }
+    return p, nil
+}
+
+func (p *stagesProfile) Rate(elapsed time.Duration) float64 {
+    for _, s := range p.stages {
+        if elapsed < s.duration {
+            return s.from + (s.to - s.from) * elapsed.Seconds() / s.duration.Seconds()
+        }
+        elapsed -= s.duration
+    }
+    return 0
+}
+
+func (p *stagesProfile) Duration() time.Duration {
+    return p.duration
+}
+
+// Mean of the planned rate from one moment to another, nothing is planned after the profile ends.
+func plannedRate(p LoadProfile, from, to time.Duration) float64 {
+    sum, n := float64(0), 0
+    for t := from; t < to; t += profileStep {
+        if t < p.Duration() {
+            sum += p.Rate(t)
+        }
+        n++
+    }
+    if n == 0 {
+        return 0
+    }
+    return sum / float64(n)
+}
+
+// profileTicker tells when each request is intended to be sent by the profile.
+// The n-th request is sent when the planned requests so far reach n, which is the integral of the rate.
+type profileTicker struct {
+    profile LoadProfile
+    elapsed float64 // Seconds
+    end     float64
+    started bool
+}
+
+func newProfileTicker(profile LoadProfile) *profileTicker {
+    // Tolerate the rounding errors, so the request at the very end is not sent
+    return &profileTicker{profile: profile, end: profile.Duration().Seconds() - 1e-6}
+}
+
+// The elapsed time of the next request, false if the profile ends before it.
+func (t *profileTicker) next() (time.Duration, bool) {
+    need := float64(1)
+    if !t.started {
+        // The first request is sent as soon as the rate is positive
+        t.started, need = true, 0
+    }
+    step := profileStep.Seconds()
+    for t.elapsed < t.end {
+        rate := t.profile.Rate(time.Duration(t.elapsed * float64(time.Second)))
+        switch {
+        case rate <= 0:
+            t.elapsed += step
+        case need <= 0:
+            return time.Duration(t.elapsed * float64(time.Second)), true
+        case need / rate > step:
+            t.elapsed += step
+            need -= rate * step
+        default:
+            t.elapsed += need / rate
+            need = 0
+        }
+    }
+    return 0, false
+}
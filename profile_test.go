@@ -0,0 +1,170 @@
+package main
+
+import (
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+    du := 60 * time.Second
+    tests := []struct {
+        spec  string
+        rates map[time.Duration]float64 // Planned rate at the elapsed time
+    }{
+        {"", map[time.Duration]float64{0: 100, 59 * time.Second: 100}},
+        {"ramp:10-500", map[time.Duration]float64{0: 10, 30 * time.Second: 255, 60 * time.Second: 500}},
+        {"ramp:500-0", map[time.Duration]float64{0: 500, 30 * time.Second: 250}},
+        {"step:100,50,10s", map[time.Duration]float64{0: 100, 9 * time.Second: 100, 10 * time.Second: 150,
+            35 * time.Second: 250}},
+        {"step:100,-50,10s", map[time.Duration]float64{10 * time.Second: 50, 30 * time.Second: 0}},
+        {"sine:500,200,1m", map[time.Duration]float64{0: 500, 15 * time.Second: 700, 45 * time.Second: 300}},
+        {"sine:100,200,1m", map[time.Duration]float64{45 * time.Second: 0}},
+        {"spike:100,1000,30s,5s", map[time.Duration]float64{0: 100, 4 * time.Second: 100, 30 * time.Second: 1000,
+            34 * time.Second: 1000, 35 * time.Second: 100}},
+    }
+    for _, tt := range tests {
+        p, err := ParseLoadProfile(tt.spec, 100, du)
+        if err != nil {
+            t.Errorf("%q: %s", tt.spec, err)
+            continue
+        }
+        if p.Duration() != du {
+            t.Errorf("%q: duration = %v, want %v", tt.spec, p.Duration(), du)
+        }
+        for elapsed, want := range tt.rates {
+            if got := p.Rate(elapsed); math.Abs(got - want) > 1e-9 {
+                t.Errorf("%q: rate at %v = %v, want %v", tt.spec, elapsed, got, want)
+            }
+        }
+    }
+}
+
+func TestParseLoadProfileErrors(t *testing.T) {
+    tests := []struct {
+        spec string
+        err  string
+    }{
+        {"ramp", "not valid load profile"},
+        {"wave:1,2", "unknown load profile"},
+        {"ramp:10", "expects 2 arguments"},
+        {"step:100,50", "expects 3 arguments"},
+        {"ramp:x-10", "not valid number"},
+        {"ramp:10--5", "negative rate"},
+        {"sine:-1,2,1m", "negative rate"},
+        {"step:100,50,0s", "not valid duration"},
+        {"spike:100,1000,30s,soon", "not valid duration"},
+        {"@/no/such/profile", "no such file"},
+    }
+    for _, tt := range tests {
+        if _, err := ParseLoadProfile(tt.spec, 100, time.Minute); err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%q: error = %v, want containing %q", tt.spec, err, tt.err)
+        }
+    }
+}
+
+func writeProfile(t *testing.T, content string) string {
+    file := filepath.Join(t.TempDir(), "profile.txt")
+    if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return file
+}
+
+func TestReadStagesProfile(t *testing.T) {
+    file := writeProfile(t, "# warm up, hold, then ramp down\n30s 0-100\n\n1m 100\n30s 100-0\n")
+    p, err := ParseLoadProfile("@" + file, 1, time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.Duration() != 2 * time.Minute {
+        t.Errorf("duration = %v, want 2m", p.Duration())
+    }
+    rates := map[time.Duration]float64{
+        0: 0, 15 * time.Second: 50, 30 * time.Second: 100, 80 * time.Second: 100, 105 * time.Second: 50,
+        2 * time.Minute: 0,
+    }
+    for elapsed, want := range rates {
+        if got := p.Rate(elapsed); math.Abs(got - want) > 1e-9 {
+            t.Errorf("rate at %v = %v, want %v", elapsed, got, want)
+        }
+    }
+}
+
+func TestReadStagesProfileErrors(t *testing.T) {
+    tests := []struct {
+        content string
+        err     string
+    }{
+        {"# nothing\n", "no stages"},
+        {"30s\n", ":1: expect a duration and a rate"},
+        {"30s 10\nsoon 10\n", ":2: not valid duration"},
+        {"30s 10-x\n", ":1: not valid rate"},
+        {"30s -10\n", ":1: not valid rate"},
+    }
+    for _, tt := range tests {
+        _, err := ReadStagesProfile(writeProfile(t, tt.content))
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%q: error = %v, want containing %q", tt.content, err, tt.err)
+        }
+    }
+}
+
+func TestPlannedRate(t *testing.T) {
+    p, _ := ParseLoadProfile("ramp:0-100", 0, 10 * time.Second)
+    tests := []struct {
+        from, to time.Duration
+        want     float64
+    }{
+        {0, time.Second, 5},
+        {9 * time.Second, 10 * time.Second, 95},
+        // Nothing planned after the end
+        {9 * time.Second, 11 * time.Second, 47.5},
+        {10 * time.Second, 11 * time.Second, 0},
+    }
+    for _, tt := range tests {
+        if got := plannedRate(p, tt.from, tt.to); math.Abs(got - tt.want) > 0.1 {
+            t.Errorf("planned rate from %v to %v = %v, want %v", tt.from, tt.to, got, tt.want)
+        }
+    }
+}
+
+// The ticks of a profile follow the integral of the rate
+func TestProfileTicker(t *testing.T) {
+    tests := []struct {
+        spec  string
+        du    time.Duration
+        ticks int
+    }{
+        {"", 2 * time.Second, 200},
+        {"ramp:0-100", 10 * time.Second, 500},
+        {"step:0,100,1s", 3 * time.Second, 300},
+        {"spike:0,100,1s,500ms", 3 * time.Second, 100},
+    }
+    for _, tt := range tests {
+        p, err := ParseLoadProfile(tt.spec, 100, tt.du)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ticker := newProfileTicker(p)
+        n, last := 0, time.Duration(-1)
+        for {
+            at, ok := ticker.next()
+            if !ok {
+                break
+            }
+            if at < last || at >= tt.du {
+                t.Errorf("%q: tick %d at %v after %v", tt.spec, n, at, last)
+                break
+            }
+            last = at
+            n++
+        }
+        if math.Abs(float64(n - tt.ticks)) > 1 {
+            t.Errorf("%q: %d ticks, want %d", tt.spec, n, tt.ticks)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "time"
+)
+
+// How often the progress is refreshed
+const progressInterval = time.Second
+
+// Progress shows the live status of the attack, refreshed every second from the damages received.
+// On a terminal the status line is redrawn in place, otherwise a plain line is printed each time.
+type Progress struct {
+    out      *os.File
+    tty      bool
+    missile  *Missile
+    started  time.Time
+    duration time.Duration // Planned duration, in rate mode
+    total    int           // Planned requests, in -n mode or closed loop of iterations
+    profile  LoadProfile   // Planned rate, in rate mode
+
+    completed int
+    failed    int
+
+    // Stats since the last refresh
+    windowStart     time.Time
+    windowCompleted int
+    windowFailed    int
+    windowLatencies *Histogram
+}
+
+// Create a progress writing to out, for the attack planned by opts and the load profile.
+func NewProgress(out *os.File, opts *BoomOptions, missile *Missile, profile LoadProfile) *Progress {
+    p := &Progress{
+        out: out,
+        tty: isTerminal(out),
+        missile: missile,
+        started: time.Now(),
+        windowLatencies: NewLatencyHistogram(),
+    }
+    p.windowStart = p.started
+    switch {
+    case opts.TotalRequests > 0:
+        p.total = opts.TotalRequests
+    case opts.ClosedLoop && opts.Iterations > 0:
+        p.total = opts.Iterations * missile.ctrl.Warheads
+    case opts.ClosedLoop:
+        p.duration = opts.RequestDuration
+    case profile != nil:
+        p.duration = profile.Duration()
+        p.profile = profile
+    }
+    return p
+}
+
+// Count a damage in
+func (p *Progress) add(damage *Damage) {
+    p.completed++
+    p.windowCompleted++
+    if damage.Error != "" {
+        p.failed++
+        p.windowFailed++
+    }
+    p.windowLatencies.RecordDuration(damage.Latency)
+}
+
+// Show the status since the last refresh, then start a new window.
+func (p *Progress) render(now time.Time) {
+    elapsed := now.Sub(p.started)
+    rps := float64(p.windowCompleted) / now.Sub(p.windowStart).Seconds()
+    errorRate := float64(0)
+    if p.windowCompleted > 0 {
+        errorRate = float64(p.windowFailed) / float64(p.windowCompleted)
+    }
+    status := fmt.Sprintf("[%s%s] rps: %.1f%s, in-flight: %d, errors: %.2f%%, p50: %s, p99: %s, done: %d, failed: %d",
+        formatElapsed(elapsed), p.eta(elapsed), rps, p.planned(elapsed), p.missile.InFlight(), errorRate * 100,
+        formatLatency(p.windowLatencies.ValueAt(50)), formatLatency(p.windowLatencies.ValueAt(99)),
+        p.completed, p.failed)
+    if p.tty {
+        // Return to the line start and clear the line
+        fmt.Fprintf(p.out, "\r\033[K%s", status)
+    } else {
+        fmt.Fprintln(p.out, status)
+    }
+
+    p.windowStart = now
+    p.windowCompleted, p.windowFailed = 0, 0
+    p.windowLatencies.Reset()
+}
+
+// End the status line on a terminal, so the report starts from a new line.
+func (p *Progress) finish() {
+    if p.tty {
+        fmt.Fprintln(p.out)
+    }
+}
+
+// Elapsed and remaining time, eg. " / 1m0s, ETA 48s"
+func (p *Progress) eta(elapsed time.Duration) string {
+    switch {
+    case p.duration > 0:
+        remaining := p.duration - elapsed
+        if remaining < 0 {
+            remaining = 0
+        }
+        return fmt.Sprintf(" / %s, ETA %s", formatElapsed(p.duration), formatElapsed(remaining))
+    case p.total > 0 && p.completed > 0:
+        remaining := time.Duration(float64(elapsed) * float64(p.total - p.completed) / float64(p.completed))
+        return fmt.Sprintf(", ETA %s", formatElapsed(remaining))
+    }
+    return ""
+}
+
+// The rate planned now, eg. "/1000"
+func (p *Progress) planned(elapsed time.Duration) string {
+    if p.profile != nil {
+        return fmt.Sprintf("/%.0f", p.profile.Rate(elapsed))
+    }
+    return ""
+}
+
+func formatElapsed(d time.Duration) string {
+    return d.Round(time.Second).String()
+}
+
+func formatLatency(ns int64) string {
+    return fmt.Sprintf("%.2fms", float64(ns) / float64(time.Millisecond))
+}
+
+// Whether the file is a terminal
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode() & os.ModeCharDevice != 0
+}
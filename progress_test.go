@@ -0,0 +1,94 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestNewProgressPlan(t *testing.T) {
+    missile := NewMissile()
+    missile.ctrl.Warheads = 4
+    profile := &constantProfile{100, time.Minute}
+    tests := []struct {
+        name     string
+        opts     *BoomOptions
+        profile  LoadProfile
+        total    int
+        duration time.Duration
+    }{
+        {"requests", &BoomOptions{TotalRequests: 50}, nil, 50, 0},
+        {"iterations", &BoomOptions{ClosedLoop: true, Iterations: 3}, nil, 12, 0},
+        {"closed loop", &BoomOptions{ClosedLoop: true, RequestDuration: 30 * time.Second}, nil, 0, 30 * time.Second},
+        {"rate", &BoomOptions{}, profile, 0, time.Minute},
+    }
+    for _, tt := range tests {
+        p := NewProgress(os.Stderr, tt.opts, missile, tt.profile)
+        if p.total != tt.total || p.duration != tt.duration {
+            t.Errorf("%s: total %d, duration %v, want %d, %v", tt.name, p.total, p.duration, tt.total, tt.duration)
+        }
+    }
+}
+
+func TestProgressETA(t *testing.T) {
+    tests := []struct {
+        p       *Progress
+        elapsed time.Duration
+        want    string
+    }{
+        {&Progress{duration: time.Minute}, 12 * time.Second, " / 1m0s, ETA 48s"},
+        {&Progress{duration: time.Minute}, 70 * time.Second, " / 1m0s, ETA 0s"},
+        {&Progress{total: 100, completed: 25}, 10 * time.Second, ", ETA 30s"},
+        {&Progress{total: 100}, 10 * time.Second, ""},
+        {&Progress{}, 10 * time.Second, ""},
+    }
+    for _, tt := range tests {
+        if got := tt.p.eta(tt.elapsed); got != tt.want {
+            t.Errorf("eta(%v) = %q, want %q", tt.elapsed, got, tt.want)
+        }
+    }
+}
+
+// Off a terminal, a plain line is printed each refresh, and the window is reset
+func TestProgressRender(t *testing.T) {
+    out, err := ioutil.TempFile("", "progress")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(out.Name())
+    defer out.Close()
+
+    p := NewProgress(out, &BoomOptions{}, NewMissile(), &constantProfile{200, time.Minute})
+    if p.tty {
+        t.Fatal("a file is taken as a terminal")
+    }
+    start := p.started
+    for i := 0; i < 10; i++ {
+        damage := &Damage{Latency: time.Duration(i + 1) * time.Millisecond}
+        if i < 2 {
+            damage.Error = "refused"
+        }
+        p.add(damage)
+    }
+    p.render(start.Add(2 * time.Second))
+    p.add(&Damage{Latency: time.Millisecond})
+    p.render(start.Add(3 * time.Second))
+    p.finish()
+
+    data, _ := ioutil.ReadFile(out.Name())
+    lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+    want := []string{
+        "[2s / 1m0s, ETA 58s] rps: 5.0/200, in-flight: 0, errors: 20.00%, p50: 5.01ms, p99: 10.00ms, done: 10, failed: 2",
+        "[3s / 1m0s, ETA 57s] rps: 1.0/200, in-flight: 0, errors: 0.00%, p50: 1.00ms, p99: 1.00ms, done: 11, failed: 2",
+    }
+    if len(lines) != len(want) {
+        t.Fatalf("progress printed %q", data)
+    }
+    for i := range want {
+        if lines[i] != want[i] {
+            t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+        }
+    }
+}
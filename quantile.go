@@ -0,0 +1,115 @@
+package main
+
+import (
+    "math"
+    "sort"
+)
+
+// Quantile estimates a quantile of a stream of values in constant space, by the P² algorithm of Jain and Chlamtac.
+// Five markers are kept at the minimum, the p/2, p, (1+p)/2 quantiles and the maximum, they are moved by
+// piecewise-parabolic interpolation as values arrive. The estimate is rough for a few values, and close to the
+// exact quantile for many of them.
+type Quantile struct {
+    p      float64
+    n      int        // Values seen
+    height [5]float64 // Values at the markers, the first five values until there are five
+    pos    [5]int     // Positions of the markers, 1 based
+}
+
+// Create an estimator of the quantile p in [0, 1], eg. 0.99
+func NewQuantile(p float64) Quantile {
+    return Quantile{p: p}
+}
+
+// Add a value to the stream
+func (q *Quantile) Add(v float64) {
+    if q.n < len(q.height) {
+        q.height[q.n] = v
+        q.n++
+        if q.n == len(q.height) {
+            sort.Float64s(q.height[:])
+            for i := range q.pos {
+                q.pos[i] = i + 1
+            }
+        }
+        return
+    }
+
+    // Find the cell the value falls in, the extreme markers are moved to it if it's out of them
+    var k int
+    switch {
+    case v < q.height[0]:
+        q.height[0] = v
+        k = 0
+    case v >= q.height[4]:
+        q.height[4] = v
+        k = 3
+    default:
+        for k = 0; k < 3 && v >= q.height[k + 1]; k++ {
+        }
+    }
+    for i := k + 1; i < len(q.pos); i++ {
+        q.pos[i]++
+    }
+    q.n++
+
+    // Move the middle markers which are off their desired positions by one or more
+    for i := 1; i <= 3; i++ {
+        d := q.desired(i) - float64(q.pos[i])
+        if d >= 1 && q.pos[i + 1] - q.pos[i] > 1 || d <= -1 && q.pos[i - 1] - q.pos[i] < -1 {
+            s := 1
+            if d < 0 {
+                s = -1
+            }
+            h := q.parabolic(i, s)
+            if h <= q.height[i - 1] || h >= q.height[i + 1] {
+                h = q.linear(i, s)
+            }
+            q.height[i] = h
+            q.pos[i] += s
+        }
+    }
+}
+
+// The desired position of the marker i
+func (q *Quantile) desired(i int) float64 {
+    increments := [5]float64{0, q.p / 2, q.p, (1 + q.p) / 2, 1}
+    return 1 + float64(q.n - 1) * increments[i]
+}
+
+// The height of the marker i moved by s, interpolated by the parabola through it and its neighbours
+func (q *Quantile) parabolic(i, s int) float64 {
+    n0, n1, n2 := float64(q.pos[i - 1]), float64(q.pos[i]), float64(q.pos[i + 1])
+    h0, h1, h2 := q.height[i - 1], q.height[i], q.height[i + 1]
+    d := float64(s)
+    return h1 + d / (n2 - n0) * ((n1 - n0 + d) * (h2 - h1) / (n2 - n1) + (n2 - n1 - d) * (h1 - h0) / (n1 - n0))
+}
+
+// The height of the marker i moved by s, interpolated linearly to the neighbour it's moved to
+func (q *Quantile) linear(i, s int) float64 {
+    return q.height[i] + float64(s) * (q.height[i + s] - q.height[i]) / float64(q.pos[i + s] - q.pos[i])
+}
+
+// The estimated quantile, 0 if no value is added
+func (q *Quantile) Value() float64 {
+    if q.n == 0 {
+        return 0
+    }
+    if q.n < len(q.height) {
+        // Nearest rank of the few values seen
+        values := make([]float64, q.n)
+        copy(values, q.height[:q.n])
+        sort.Float64s(values)
+        rank := int(math.Ceil(q.p * float64(q.n)))
+        if rank < 1 {
+            rank = 1
+        }
+        return values[rank - 1]
+    }
+    return q.height[2]
+}
+
+// How many values are added
+func (q *Quantile) Count() int {
+    return q.n
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+)
+
+func TestQuantileFewValues(t *testing.T) {
+    tests := []struct {
+        p      float64
+        values []float64
+        want   float64
+    }{
+        {0.5, nil, 0},
+        {0.5, []float64{7}, 7},
+        {0.99, []float64{7}, 7},
+        {0.5, []float64{3, 1, 2}, 2},
+        {0.5, []float64{4, 1, 3, 2}, 2},
+        {0.9, []float64{4, 1, 3, 2}, 4},
+        {0.5, []float64{5, 1, 4, 2, 3}, 3},
+    }
+    for _, tt := range tests {
+        q := NewQuantile(tt.p)
+        for _, v := range tt.values {
+            q.Add(v)
+        }
+        if got := q.Value(); got != tt.want {
+            t.Errorf("p%v of %v = %v, want %v", tt.p * 100, tt.values, got, tt.want)
+        }
+        if q.Count() != len(tt.values) {
+            t.Errorf("count of %v = %d", tt.values, q.Count())
+        }
+    }
+}
+
+func TestQuantileEstimates(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+    streams := []struct {
+        name string
+        next func(i int) float64
+        // Exact quantile of the distribution
+        exact func(p float64) float64
+    }{
+        {"ascending", func(i int) float64 { return float64(i) }, func(p float64) float64 { return p * 100000 }},
+        {"descending", func(i int) float64 { return float64(100000 - i) }, func(p float64) float64 { return p * 100000 }},
+        {"uniform", func(i int) float64 { return r.Float64() * 1000 }, func(p float64) float64 { return p * 1000 }},
+        {"exponential", func(i int) float64 { return r.ExpFloat64() * 100 },
+            func(p float64) float64 { return -math.Log(1 - p) * 100 }},
+    }
+    for _, s := range streams {
+        for _, p := range []float64{0.5, 0.9, 0.99} {
+            q := NewQuantile(p)
+            for i := 0; i < 100000; i++ {
+                q.Add(s.next(i))
+            }
+            want := s.exact(p)
+            if diff := math.Abs(q.Value() - want) / want; diff > 0.03 {
+                t.Errorf("%s: p%v = %.2f, want %.2f within 3%%", s.name, p * 100, q.Value(), want)
+            }
+        }
+    }
+}
@@ -5,6 +5,25 @@ import (
     "time"
     "sort"
     "log"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "encoding/json"
+    "encoding/csv"
+    "reflect"
+    "math"
+    "strconv"
+)
+
+// How many buckets the latency histogram has
+const histogramBuckets = 10
+
+// Supported report formats
+const (
+    formatText = "text"
+    formatJSON = "json"
+    formatCSV = "csv"
 )
 
 // Server(Target) information
@@ -33,60 +52,148 @@ type Report struct {
     MinLatency                float64 `json:"min_latency"`
     MaxLatency                float64 `json:"max_latency"`
     MeanLatency               float64 `json:"mean_latency"`
+    LatencyStdDev             float64 `json:"latency_stddev"`
+    LatencyPercentiles        *LatencyPercentiles `json:"latency_percentiles"`
+    LatencyHistogram          []*HistogramBucket `json:"latency_histogram"`
+    CorrectedLatency          *CorrectedLatency `json:"corrected_latency,omitempty"` // Only in rate mode
+    DroppedTicks              int64 `json:"dropped_ticks"` // Requests not sent in open loop, as -max-workers are busy
+    LateTicks                 int64 `json:"late_ticks"`    // Requests sent late in open loop
+    Timings                   *TimingReport `json:"timings,omitempty"` // Phases of the requests, nil if none are traced
+    StatusCodes               map[string]int `json:"status_codes"`   // Responses of each status code, eg. 200
+    StatusClasses             map[string]int `json:"status_classes"` // Responses of each status class, eg. 2xx
+    Statuses                  map[string]int `json:"statuses,omitempty"` // Responses of each status of other protocols, eg. OK of gRPC
+    Protocols                 map[string]int `json:"protocols"`      // Responses of each protocol, eg. HTTP/2.0
+    Errors                    []*ErrorCount `json:"errors"`          // Errors of each kind and cause, most first
+    Assertions                []*AssertionResult `json:"assertions,omitempty"` // Results of -assert
+    Targets                   []*TargetReport `json:"targets,omitempty"` // Stats of each target, only if more than one
+    TimeSeries                []*TimeSeriesRow `json:"time_series,omitempty"`
+    Damages                   []*Damage `json:"damages,omitempty"` // Every damage, only in raw mode
 }
 
-type ReportTimeSlice []time.Time
-type ReportLatencySlice []time.Duration
+// Stats of a single target
+type TargetReport struct {
+    Target             string `json:"target"`
+    Weight             int `json:"weight"`
+    PlannedShare       float64 `json:"planned_share"` // Share of requests by the weights
+    Share              float64 `json:"share"`         // Share of requests actually sent
+    CompletedRequests  int `json:"completed_requests"`
+    FailedRequests     int `json:"failed_requests"`
+    SuccessRate        float64 `json:"success_rate"`
+    TotalSentBytes     uint64 `json:"total_send_bytes"`
+    TotalReceivedBytes uint64 `json:"total_received_bytes"`
+    RequestPerSecond   float64 `json:"request_per_second"`
+    MinLatency         float64 `json:"min_latency"`
+    MaxLatency         float64 `json:"max_latency"`
+    MeanLatency        float64 `json:"mean_latency"`
+    LatencyPercentiles *LatencyPercentiles `json:"latency_percentiles"`
+}
+
+// Time spent in each phase of the requests, to tell whether slowness comes from the network, TLS or the server
+type TimingReport struct {
+    DNSLookup         *PhaseTiming `json:"dns_lookup"`
+    Connect           *PhaseTiming `json:"connect"`
+    TLSHandshake      *PhaseTiming `json:"tls_handshake"` // QUIC handshake over HTTP/3
+    Handshake         *PhaseTiming `json:"handshake"`     // WebSocket upgrade
+    FirstByte         *PhaseTiming `json:"first_byte"` // From the request written to the first response byte
+    Download          *PhaseTiming `json:"download"`
+    NewConnections    int `json:"new_connections"`    // Responses on new connections
+    ReusedConnections int `json:"reused_connections"` // Responses on reused connections
+    ZeroRTT           int `json:"zero_rtt"`           // Responses on QUIC connections used 0-RTT
+}
 
-// Damages buffer use to store the damages received from damage channel.
-// Boom generates the final report when the channel is closed or the process is killed.
-var damagesBuffer = make([]*Damage, 0)
+// Stats of a phase in seconds, Count is how many requests went through it
+type PhaseTiming struct {
+    Count int `json:"count"`
+    Mean  float64 `json:"mean"`
+    P50   float64 `json:"p50"`
+    P99   float64 `json:"p99"`
+    Max   float64 `json:"max"`
+}
 
+// Count of the errors of the same kind and cause
+type ErrorCount struct {
+    Kind  string `json:"kind"`
+    Error string `json:"error"`
+    Count int `json:"count"`
+}
 
-// Receive the damages come from channel
-func collectDamage(damage *Damage) {
-    damagesBuffer = append(damagesBuffer, damage)
+// Stats of the requests sent in an interval
+type TimeSeriesRow struct {
+    Start              float64 `json:"start"` // Seconds since the test started
+    PlannedRate        float64 `json:"planned_rate"` // Mean requests per second planned by the load profile
+    RequestPerSecond   float64 `json:"request_per_second"`
+    CompletedRequests  int `json:"completed_requests"`
+    SuccessRequests    int `json:"success_requests"`
+    FailedRequests     int `json:"failed_requests"`
+    TotalSentBytes     uint64 `json:"total_send_bytes"`
+    TotalReceivedBytes uint64 `json:"total_received_bytes"`
+    MinLatency         float64 `json:"min_latency"`
+    MeanLatency        float64 `json:"mean_latency"`
+    MaxLatency         float64 `json:"max_latency"`
+    P50                float64 `json:"p50"` // Percentiles are estimated in constant space, see Quantile
+    P90                float64 `json:"p90"`
+    P99                float64 `json:"p99"`
 }
-func receiveDamages(damageCh <-chan *Damage) {
-    for damage := range damageCh {
-        damagesBuffer = append(damagesBuffer, damage)
-    }
+
+// Latencies in seconds at which the given percent of requests completed
+type LatencyPercentiles struct {
+    P50  float64 `json:"p50"`
+    P75  float64 `json:"p75"`
+    P90  float64 `json:"p90"`
+    P95  float64 `json:"p95"`
+    P99  float64 `json:"p99"`
+    P999 float64 `json:"p999"`
 }
 
-// Create a Report
-func createReport(boomOpts *BoomOptions) (report *Report) {
+// Latencies in seconds from the intended send time of requests instead of the actual one.
+// When the server is too slow to keep up with the rate, requests are sent late,
+// the delay is counted here but not in the latencies from the actual send time.
+type CorrectedLatency struct {
+    MeanLatency        float64 `json:"mean_latency"`
+    MaxLatency         float64 `json:"max_latency"`
+    LatencyPercentiles *LatencyPercentiles `json:"latency_percentiles"`
+}
+
+// A bucket of the latency histogram, holds the requests whose latency(in seconds) is in [LowerBound, UpperBound)
+type HistogramBucket struct {
+    LowerBound float64 `json:"lower_bound"`
+    UpperBound float64 `json:"upper_bound"`
+    Count      int `json:"count"`
+}
+
+type ReportLatencySlice []time.Duration
+
+// Create a Report, then print it or write it to the file of -o
+func createReport(boomOpts *BoomOptions, collector *Collector, targets *Targets, assertions []*Assertion) (report *Report) {
     // fmt.Println("Generating boom report, please be patient... :-) ")
-    if len(damagesBuffer) <= 0 {
+    report = newReport(boomOpts, collector, targets, assertions)
+    if report == nil {
+        return nil
+    }
+    if boomOpts.ResultOutput != "Stdout" {
+        if err := report.writeToFile(boomOpts.ResultOutput, boomOpts.ResultFormat); err != nil {
+            fmt.Fprintf(os.Stderr, "Write report to %s error: %s\n", boomOpts.ResultOutput, err)
+            report.prettyPrintToConsole()
+        }
+    } else {
+        report.prettyPrintToConsole()
+    }
+    if boomOpts.TimeSeriesOutput != "" {
+        if err := report.writeTimeSeries(boomOpts.TimeSeriesOutput); err != nil {
+            fmt.Fprintf(os.Stderr, "Write time series to %s error: %s\n", boomOpts.TimeSeriesOutput, err)
+        }
+    }
+    return report
+}
+
+// Create a Report from the collected damages, nil if there is no damage.
+func newReport(boomOpts *BoomOptions, collector *Collector, targets *Targets, assertions []*Assertion) (report *Report) {
+    total := collector.total
+    if total.completedRequests <= 0 {
         log.Println("No damages.")
         return nil
     }
-    var (
-        failedRequests = 0
-        completedRequests = 0
-        totalSentBytes = uint64(0)
-        totalReceivedBytes = uint64(0)
-        totalLatency = float64(0)
-        startTimestamps = make(ReportTimeSlice, 0)
-        latencies = make(ReportLatencySlice, 0)
-        endTimeStamps = make(ReportTimeSlice, 0)
-    )
-    for _, damage := range damagesBuffer {
-        completedRequests++
-        if damage.Error != "" {
-            log.Println(damage.Error)
-            failedRequests++
-        }
-        totalSentBytes += damage.SentBytes
-        totalReceivedBytes += damage.ReceivedBytes
-        startTimestamps = append(startTimestamps, damage.Timestamp)
-        latencies = append(latencies, damage.Latency)
-        endTimeStamps = append(endTimeStamps, damage.EndTime)
-        totalLatency += damage.Latency.Seconds()
-    }
-
-    sort.Sort(startTimestamps)
-    sort.Sort(endTimeStamps)
-    sort.Sort(latencies)
+    completedRequests := total.completedRequests
 
     report = &Report{}
 
@@ -94,20 +201,16 @@ func createReport(boomOpts *BoomOptions) (report *Report) {
 
     // requests
     report.CompletedRequests = completedRequests
-    report.FailedRequests = failedRequests
-    report.SuccessRate = float64(completedRequests - failedRequests) / float64(completedRequests)
+    report.FailedRequests = total.failedRequests
+    report.SuccessRate = float64(completedRequests - total.failedRequests) / float64(completedRequests)
 
     // bytes
-    report.TotalReceivedBytes = totalReceivedBytes
-    report.TotalSentBytes = totalSentBytes
-    report.TotalTransferred = totalSentBytes + totalReceivedBytes
-
-    firstRequestSendTime := startTimestamps[0]
-    lastRequestCompletedTime := endTimeStamps[len(endTimeStamps) - 1]
-    lastRequestCompletedTime.Sub(firstRequestSendTime)
+    report.TotalReceivedBytes = total.totalReceivedBytes
+    report.TotalSentBytes = total.totalSentBytes
+    report.TotalTransferred = total.totalSentBytes + total.totalReceivedBytes
 
     // TimeTaken = (First request sent) - (Last request response)
-    report.TimeTaken = lastRequestCompletedTime.Sub(firstRequestSendTime).Seconds()
+    report.TimeTaken = total.timeTaken().Seconds()
     // RequestPerSecond = (Complete requests) / (Time taken for tests)
     report.RequestPerSecond = float64(completedRequests) / report.TimeTaken
     // TransferRate = (Total transferred bytes) / (Time taken for tests)
@@ -119,60 +222,527 @@ func createReport(boomOpts *BoomOptions) (report *Report) {
     report.TimePerRequestConcurrency = report.TimeTaken / float64(completedRequests)
 
     // Latency
-    report.MeanLatency = totalLatency / float64(completedRequests)
-    report.MaxLatency = latencies[len(latencies) - 1].Seconds()
-    report.MinLatency = latencies[0].Seconds()
-
-    if boomOpts.ResultOutput != "Stdout" {
-        report.writeToFile(boomOpts.ResultOutput)
+    if collector.raw {
+        // Exact latencies from every damage
+        latencies := make(ReportLatencySlice, 0, len(collector.damages))
+        totalLatency := float64(0)
+        for _, damage := range collector.damages {
+            latencies = append(latencies, damage.Latency)
+            totalLatency += damage.Latency.Seconds()
+        }
+        sort.Sort(latencies)
+        report.MeanLatency = totalLatency / float64(completedRequests)
+        report.MaxLatency = latencies[len(latencies) - 1].Seconds()
+        report.MinLatency = latencies[0].Seconds()
+        report.LatencyStdDev = latencies.stdDev(report.MeanLatency)
+        report.LatencyPercentiles = &LatencyPercentiles{
+            P50: latencies.percentile(50),
+            P75: latencies.percentile(75),
+            P90: latencies.percentile(90),
+            P95: latencies.percentile(95),
+            P99: latencies.percentile(99),
+            P999: latencies.percentile(99.9),
+        }
+        report.LatencyHistogram = latencies.histogram(histogramBuckets)
+        report.Damages = collector.damages
+        if boomOpts.openLoop() {
+            corrected := make(ReportLatencySlice, 0, len(collector.damages))
+            totalCorrected := float64(0)
+            for _, damage := range collector.damages {
+                corrected = append(corrected, damage.correctedLatency())
+                totalCorrected += damage.correctedLatency().Seconds()
+            }
+            sort.Sort(corrected)
+            report.CorrectedLatency = &CorrectedLatency{
+                MeanLatency: totalCorrected / float64(completedRequests),
+                MaxLatency: corrected[len(corrected) - 1].Seconds(),
+                LatencyPercentiles: &LatencyPercentiles{
+                    P50: corrected.percentile(50),
+                    P75: corrected.percentile(75),
+                    P90: corrected.percentile(90),
+                    P95: corrected.percentile(95),
+                    P99: corrected.percentile(99),
+                    P999: corrected.percentile(99.9),
+                },
+            }
+        }
     } else {
-        report.prettyPrintToConsole()
+        h := total.latencies
+        report.MeanLatency = h.Mean() / float64(time.Second)
+        report.MaxLatency = time.Duration(h.Max()).Seconds()
+        report.MinLatency = time.Duration(h.Min()).Seconds()
+        report.LatencyStdDev = h.StdDev() / float64(time.Second)
+        report.LatencyPercentiles = percentilesOf(h)
+        report.LatencyHistogram = histogramOf(h, histogramBuckets)
+        if boomOpts.openLoop() {
+            c := collector.corrected
+            report.CorrectedLatency = &CorrectedLatency{
+                MeanLatency: c.Mean() / float64(time.Second),
+                MaxLatency: time.Duration(c.Max()).Seconds(),
+                LatencyPercentiles: percentilesOf(c),
+            }
+        }
+    }
+
+    report.DroppedTicks = collector.dropped
+    report.LateTicks = collector.late
+
+    // Phases
+    report.Timings = createTimingReport(collector.timings)
+
+    // Status codes and errors
+    report.StatusCodes = make(map[string]int)
+    report.StatusClasses = make(map[string]int)
+    for code, count := range collector.statusCodes {
+        report.StatusCodes[strconv.Itoa(code)] += count
+        report.StatusClasses[fmt.Sprintf("%dxx", code / 100)] += count
+    }
+    if len(collector.statuses) > 0 {
+        report.Statuses = make(map[string]int)
+        for status, count := range collector.statuses {
+            report.Statuses[status] = count
+        }
+    }
+    report.Protocols = make(map[string]int)
+    for proto, count := range collector.protocols {
+        report.Protocols[proto] = count
+    }
+    report.Errors = make([]*ErrorCount, 0, len(collector.errors))
+    for _, e := range collector.errors {
+        report.Errors = append(report.Errors, e)
+    }
+    sort.Sort(errorCounts(report.Errors))
+
+    // Targets
+    if len(collector.targetNames) > 1 {
+        weights := make(map[string]int)
+        for _, t := range targets.List() {
+            weights[t.Name()] += t.Weight
+        }
+        report.Targets = make([]*TargetReport, 0, len(collector.targetNames))
+        for _, name := range collector.targetNames {
+            tr := createTargetReport(name, collector.targets[name])
+            tr.Weight = weights[name]
+            tr.PlannedShare = float64(tr.Weight) / float64(targets.TotalWeight())
+            tr.Share = float64(tr.CompletedRequests) / float64(completedRequests)
+            report.Targets = append(report.Targets, tr)
+        }
+    }
+
+    // Time series
+    report.TimeSeries = createTimeSeries(collector)
+
+    // Assertions
+    for _, a := range assertions {
+        report.Assertions = append(report.Assertions, a.Check(report))
     }
     return report
+}
+
+// Create the report of a single target
+func createTargetReport(name string, stats *damageStats) *TargetReport {
+    // A target may get no hits at all, its percentiles are zero then
+    r := &TargetReport{Target: name, LatencyPercentiles: &LatencyPercentiles{}}
+    if stats.completedRequests == 0 {
+        return r
+    }
+    r.CompletedRequests = stats.completedRequests
+    r.FailedRequests = stats.failedRequests
+    r.SuccessRate = float64(stats.completedRequests - stats.failedRequests) / float64(stats.completedRequests)
+    r.TotalSentBytes = stats.totalSentBytes
+    r.TotalReceivedBytes = stats.totalReceivedBytes
+    if timeTaken := stats.timeTaken().Seconds(); timeTaken > 0 {
+        r.RequestPerSecond = float64(stats.completedRequests) / timeTaken
+    }
+    h := stats.latencies
+    r.MinLatency = time.Duration(h.Min()).Seconds()
+    r.MaxLatency = time.Duration(h.Max()).Seconds()
+    r.MeanLatency = h.Mean() / float64(time.Second)
+    r.LatencyPercentiles = percentilesOf(h)
+    return r
+}
+
+// Create the report of the phases
+func createTimingReport(stats *timingStats) *TimingReport {
+    // No phases are traced by gRPC
+    if stats.newConnections + stats.reusedConnections == 0 && stats.dnsLookup.Count() == 0 &&
+        stats.connect.Count() == 0 && stats.tlsHandshake.Count() == 0 && stats.handshake.Count() == 0 {
+        return nil
+    }
+    return &TimingReport{
+        DNSLookup: phaseTimingOf(stats.dnsLookup),
+        Connect: phaseTimingOf(stats.connect),
+        TLSHandshake: phaseTimingOf(stats.tlsHandshake),
+        Handshake: phaseTimingOf(stats.handshake),
+        FirstByte: phaseTimingOf(stats.firstByte),
+        Download: phaseTimingOf(stats.download),
+        NewConnections: stats.newConnections,
+        ReusedConnections: stats.reusedConnections,
+        ZeroRTT: stats.zeroRTT,
+    }
+}
 
+func phaseTimingOf(h *Histogram) *PhaseTiming {
+    return &PhaseTiming{
+        Count: int(h.Count()),
+        Mean: h.Mean() / float64(time.Second),
+        P50: time.Duration(h.ValueAt(50)).Seconds(),
+        P99: time.Duration(h.ValueAt(99)).Seconds(),
+        Max: time.Duration(h.Max()).Seconds(),
+    }
+}
+
+// Create the rows of time series from the first interval to the last, including the empty ones.
+func createTimeSeries(collector *Collector) []*TimeSeriesRow {
+    if len(collector.series) == 0 {
+        return nil
+    }
+    last := 0
+    for idx := range collector.series {
+        if idx > last {
+            last = idx
+        }
+    }
+    rows := make([]*TimeSeriesRow, 0, last + 1)
+    for idx := 0; idx <= last; idx++ {
+        start := time.Duration(idx) * collector.interval
+        row := &TimeSeriesRow{Start: start.Seconds()}
+        if collector.profile != nil {
+            row.PlannedRate = plannedRate(collector.profile, start, start + collector.interval)
+        }
+        if stats, ok := collector.series[idx]; ok {
+            row.CompletedRequests = stats.completedRequests
+            row.FailedRequests = stats.failedRequests
+            row.SuccessRequests = stats.completedRequests - stats.failedRequests
+            row.RequestPerSecond = float64(stats.completedRequests) / collector.interval.Seconds()
+            row.TotalSentBytes = stats.totalSentBytes
+            row.TotalReceivedBytes = stats.totalReceivedBytes
+            row.MinLatency = stats.minLatency.Seconds()
+            row.MeanLatency = (stats.totalLatency / time.Duration(stats.completedRequests)).Seconds()
+            row.MaxLatency = stats.maxLatency.Seconds()
+            row.P50 = time.Duration(stats.p50.Value()).Seconds()
+            row.P90 = time.Duration(stats.p90.Value()).Seconds()
+            row.P99 = time.Duration(stats.p99.Value()).Seconds()
+        }
+        rows = append(rows, row)
+    }
+    return rows
 }
 
 // Print report content to console
 func (r *Report) prettyPrintToConsole() {
+    r.prettyPrint(os.Stdout)
+}
 
-    fmt.Printf("Concurrency Level: %d\n", r.ConcurrencyLevel)
-    fmt.Printf("Time taken for tests: %.6fs \n", r.TimeTaken)
+// Print report content as human readable text
+func (r *Report) prettyPrint(w io.Writer) {
 
-    fmt.Printf("Complete requests: %d\n", r.CompletedRequests)
-    fmt.Printf("Failed requests: %d\n", r.FailedRequests)
-    fmt.Printf("Success Rate: %.2f %% \n", r.SuccessRate * 100)
+    fmt.Fprintf(w, "Concurrency Level: %d\n", r.ConcurrencyLevel)
+    fmt.Fprintf(w, "Time taken for tests: %.6fs \n", r.TimeTaken)
 
-    fmt.Printf("Total sent: %d bytes\n", r.TotalSentBytes)
-    fmt.Printf("Total received: %d bytes\n", r.TotalReceivedBytes)
-    fmt.Printf("Total transferred: %d bytes\n", r.TotalTransferred)
-    fmt.Printf("Transfer rate: %.3f bytes/s (mean)\n", r.TransferRate)
+    fmt.Fprintf(w, "Complete requests: %d\n", r.CompletedRequests)
+    fmt.Fprintf(w, "Failed requests: %d\n", r.FailedRequests)
+    fmt.Fprintf(w, "Success Rate: %.2f %% \n", r.SuccessRate * 100)
+
+    fmt.Fprintf(w, "Total sent: %d bytes\n", r.TotalSentBytes)
+    fmt.Fprintf(w, "Total received: %d bytes\n", r.TotalReceivedBytes)
+    fmt.Fprintf(w, "Total transferred: %d bytes\n", r.TotalTransferred)
+    fmt.Fprintf(w, "Transfer rate: %.3f bytes/s (mean)\n", r.TransferRate)
+
+    fmt.Fprintf(w, "Requests per second: %.3f (mean)\n", r.RequestPerSecond)
+    fmt.Fprintf(w, "Time per request: %.3fms (mean)\n", r.TimePerRequest * 1000)
+    fmt.Fprintf(w, "Time per request concurrency: %.3fms (mean)\n", r.TimePerRequestConcurrency * 1000)
+    fmt.Fprintf(w, "Latency(min,mean,max): %.3fms, %.3fms ,%.3fms \n", r.MinLatency * 1000, r.MeanLatency * 1000, r.MaxLatency * 1000)
+    fmt.Fprintf(w, "Latency stddev: %.3fms\n", r.LatencyStdDev * 1000)
+    if r.DroppedTicks > 0 || r.LateTicks > 0 {
+        fmt.Fprintf(w, "Dropped ticks: %d, late ticks: %d\n", r.DroppedTicks, r.LateTicks)
+    }
+
+    if p := r.LatencyPercentiles; p != nil {
+        fmt.Fprintf(w, "\nLatency distribution:\n")
+        fmt.Fprintf(w, "  50%%    %.3fms\n", p.P50 * 1000)
+        fmt.Fprintf(w, "  75%%    %.3fms\n", p.P75 * 1000)
+        fmt.Fprintf(w, "  90%%    %.3fms\n", p.P90 * 1000)
+        fmt.Fprintf(w, "  95%%    %.3fms\n", p.P95 * 1000)
+        fmt.Fprintf(w, "  99%%    %.3fms\n", p.P99 * 1000)
+        fmt.Fprintf(w, "  99.9%%  %.3fms\n", p.P999 * 1000)
+    }
+    if c := r.CorrectedLatency; c != nil {
+        p := c.LatencyPercentiles
+        fmt.Fprintf(w, "\nLatency from intended send time(corrected for coordinated omission):\n")
+        fmt.Fprintf(w, "  mean   %.3fms\n", c.MeanLatency * 1000)
+        fmt.Fprintf(w, "  50%%    %.3fms\n", p.P50 * 1000)
+        fmt.Fprintf(w, "  90%%    %.3fms\n", p.P90 * 1000)
+        fmt.Fprintf(w, "  99%%    %.3fms\n", p.P99 * 1000)
+        fmt.Fprintf(w, "  99.9%%  %.3fms\n", p.P999 * 1000)
+        fmt.Fprintf(w, "  max    %.3fms\n", c.MaxLatency * 1000)
+    }
+    if len(r.LatencyHistogram) > 0 {
+        fmt.Fprintf(w, "\nLatency histogram:\n")
+        printHistogram(w, r.LatencyHistogram)
+    }
 
-    fmt.Printf("Requests per second: %.3f (mean)\n", r.RequestPerSecond)
-    fmt.Printf("Time per request: %.3fms (mean)\n", r.TimePerRequest * 1000)
-    fmt.Printf("Time per request concurrency: %.3fms (mean)\n", r.TimePerRequestConcurrency * 1000)
-    fmt.Printf("Latency(min,mean,max): %.3fms, %.3fms ,%.3fms \n", r.MinLatency * 1000, r.MeanLatency * 1000, r.MaxLatency * 1000)
+    if t := r.Timings; t != nil {
+        fmt.Fprintf(w, "\n%-22s %7s %11s %11s %11s %11s\n", "Timing breakdown:", "count", "mean", "p50", "p99", "max")
+        printPhaseTiming(w, "DNS lookup", t.DNSLookup)
+        printPhaseTiming(w, "TCP connect", t.Connect)
+        printPhaseTiming(w, "TLS handshake", t.TLSHandshake)
+        printPhaseTiming(w, "WebSocket handshake", t.Handshake)
+        printPhaseTiming(w, "Time to first byte", t.FirstByte)
+        printPhaseTiming(w, "Download", t.Download)
+        if t.NewConnections + t.ReusedConnections > 0 {
+            fmt.Fprintf(w, "  Connections: %d new, %d reused", t.NewConnections, t.ReusedConnections)
+            if t.ZeroRTT > 0 {
+                fmt.Fprintf(w, ", %d in 0-RTT", t.ZeroRTT)
+            }
+            fmt.Fprintln(w)
+        }
+    }
+
+    if len(r.StatusCodes) > 0 {
+        fmt.Fprintf(w, "\nStatus codes:\n")
+        for _, class := range sortedKeys(r.StatusClasses) {
+            fmt.Fprintf(w, "  %s: %d", class, r.StatusClasses[class])
+            for _, code := range sortedKeys(r.StatusCodes) {
+                if code[0] == class[0] {
+                    fmt.Fprintf(w, ", [%s] %d", code, r.StatusCodes[code])
+                }
+            }
+            fmt.Fprintln(w)
+        }
+    }
+    if len(r.Statuses) > 0 {
+        fmt.Fprintf(w, "\nStatuses:\n")
+        for _, status := range sortedKeys(r.Statuses) {
+            fmt.Fprintf(w, "  %s: %d\n", status, r.Statuses[status])
+        }
+    }
+    if len(r.Protocols) > 0 {
+        fmt.Fprintf(w, "\nProtocols:\n")
+        for _, proto := range sortedKeys(r.Protocols) {
+            fmt.Fprintf(w, "  %s: %d\n", proto, r.Protocols[proto])
+        }
+    }
+    if len(r.Errors) > 0 {
+        fmt.Fprintf(w, "\nErrors:\n")
+        for _, e := range r.Errors {
+            fmt.Fprintf(w, "  %8d  %-20s %s\n", e.Count, e.Kind, e.Error)
+        }
+    }
+
+    if len(r.Assertions) > 0 {
+        fmt.Fprintf(w, "\nAssertions:\n")
+        for _, a := range r.Assertions {
+            result := "PASS"
+            if !a.Passed {
+                result = "FAIL"
+            }
+            fmt.Fprintf(w, "  [%s] %s (actual: %s)\n", result, a.Assertion, a.actualString())
+        }
+    }
+
+    if len(r.Targets) > 0 {
+        fmt.Fprintf(w, "\nTargets:\n")
+        for _, t := range r.Targets {
+            fmt.Fprintf(w, "  %s (weight: %d, planned: %.2f %%, actual: %.2f %%)\n",
+                t.Target, t.Weight, t.PlannedShare * 100, t.Share * 100)
+            fmt.Fprintf(w, "    Requests: %d, Failed: %d, Success Rate: %.2f %%, Requests per second: %.3f\n",
+                t.CompletedRequests, t.FailedRequests, t.SuccessRate * 100, t.RequestPerSecond)
+            fmt.Fprintf(w, "    Latency(min,mean,max): %.3fms, %.3fms ,%.3fms, p50: %.3fms, p99: %.3fms\n",
+                t.MinLatency * 1000, t.MeanLatency * 1000, t.MaxLatency * 1000,
+                t.LatencyPercentiles.P50 * 1000, t.LatencyPercentiles.P99 * 1000)
+        }
+    }
 
 }
 
-// Write report content to file
-func (r *Report) writeToFile(file string) {
+// Print a row of the timing breakdown, the phases never happened are skipped
+func printPhaseTiming(w io.Writer, name string, p *PhaseTiming) {
+    if p == nil || p.Count == 0 {
+        return
+    }
+    fmt.Fprintf(w, "  %-20s %7d %9.3fms %9.3fms %9.3fms %9.3fms\n",
+        name, p.Count, p.Mean * 1000, p.P50 * 1000, p.P99 * 1000, p.Max * 1000)
+}
 
+// Keys of the map in ascending order
+func sortedKeys(m map[string]int) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
 }
 
-// Forward request for length
-func (p ReportTimeSlice) Len() int {
-    return len(p)
+// Width of the longest bar in the histogram chart
+const histogramBarWidth = 40
+
+// Print the histogram as a text bar chart, each bar is labeled by the upper bound of its bucket
+func printHistogram(w io.Writer, buckets []*HistogramBucket) {
+    maxCount := 0
+    for _, b := range buckets {
+        if b.Count > maxCount {
+            maxCount = b.Count
+        }
+    }
+    for _, b := range buckets {
+        barLen := 0
+        if maxCount > 0 {
+            barLen = b.Count * histogramBarWidth / maxCount
+        }
+        fmt.Fprintf(w, "  %10.3fms [%d]\t|%s\n", b.UpperBound * 1000, b.Count, strings.Repeat("■", barLen))
+    }
 }
 
-// Define compare
-func (p ReportTimeSlice) Less(i, j int) bool {
-    return p[i].Before(p[j])
+// Write report content to file.
+// The format is one of json, csv and text. If it's empty, the format is picked by the file extension.
+func (r *Report) writeToFile(file string, format string) error {
+    return writeReportFile(file, format, r, r.prettyPrint)
 }
 
-// Define swap over an array
-func (p ReportTimeSlice) Swap(i, j int) {
-    p[i], p[j] = p[j], p[i]
+// Write a report to file in the format, the text one is written by prettyPrint.
+func writeReportFile(file string, format string, r interface{}, prettyPrint func(w io.Writer)) error {
+    if format == "" {
+        format = reportFormatOf(file)
+    }
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    switch format {
+    case formatJSON:
+        err = writeJSON(f, r)
+    case formatCSV:
+        err = writeCSV(f, r)
+    case formatText:
+        prettyPrint(f)
+    default:
+        err = errReportFormat
+    }
+    if err != nil {
+        return err
+    }
+    return f.Close()
+}
+
+// Pick the report format by file extension, text is used when unknown.
+func reportFormatOf(file string) string {
+    switch strings.ToLower(filepath.Ext(file)) {
+    case ".json":
+        return formatJSON
+    case ".csv":
+        return formatCSV
+    default:
+        return formatText
+    }
+}
+
+// Write report as an indented JSON document
+func writeJSON(w io.Writer, r interface{}) error {
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.SetEscapeHTML(false)
+    return enc.Encode(r)
+}
+
+// Write report as CSV. Each row is a metric name and its value,
+// nested fields are named by their json tags joined with dots, eg. server_info.url
+func writeCSV(w io.Writer, r interface{}) error {
+    cw := csv.NewWriter(w)
+    if err := cw.Write([]string{"metric", "value"}); err != nil {
+        return err
+    }
+    if err := cw.WriteAll(flattenFields("", reflect.ValueOf(r))); err != nil {
+        return err
+    }
+    return cw.Error()
+}
+
+// Write the time series as CSV, a header row of the json names then a row each interval
+func (r *Report) writeTimeSeries(file string) error {
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    cw := csv.NewWriter(f)
+    for i, row := range r.TimeSeries {
+        fields := flattenFields("", reflect.ValueOf(row))
+        if i == 0 {
+            header := make([]string, len(fields))
+            for j, field := range fields {
+                header[j] = field[0]
+            }
+            cw.Write(header)
+        }
+        values := make([]string, len(fields))
+        for j, field := range fields {
+            values[j] = field[1]
+        }
+        cw.Write(values)
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        return err
+    }
+    return f.Close()
+}
+
+// Flatten a value into (name, value) rows by the json tags of struct fields.
+func flattenFields(prefix string, v reflect.Value) [][]string {
+    rows := make([][]string, 0)
+    switch v.Kind() {
+    case reflect.Ptr, reflect.Interface:
+        if v.IsNil() {
+            return rows
+        }
+        return flattenFields(prefix, v.Elem())
+    case reflect.Struct:
+        if t, ok := v.Interface().(time.Time); ok {
+            return append(rows, []string{prefix, t.Format(time.RFC3339Nano)})
+        }
+        for i := 0; i < v.NumField(); i++ {
+            field := v.Type().Field(i)
+            if field.PkgPath != "" {
+                continue
+            }
+            name := strings.Split(field.Tag.Get("json"), ",")[0]
+            if name == "-" {
+                continue
+            }
+            if name == "" {
+                name = field.Name
+            }
+            if prefix != "" {
+                name = prefix + "." + name
+            }
+            rows = append(rows, flattenFields(name, v.Field(i))...)
+        }
+    case reflect.Slice, reflect.Array:
+        for i := 0; i < v.Len(); i++ {
+            rows = append(rows, flattenFields(fmt.Sprintf("%s.%d", prefix, i), v.Index(i))...)
+        }
+    case reflect.Map:
+        keys := make(map[string]reflect.Value)
+        names := make([]string, 0, v.Len())
+        for _, k := range v.MapKeys() {
+            name := fmt.Sprint(k.Interface())
+            keys[name] = k
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            rows = append(rows, flattenFields(prefix + "." + name, v.MapIndex(keys[name]))...)
+        }
+    default:
+        rows = append(rows, []string{prefix, fmt.Sprint(v.Interface())})
+    }
+    return rows
 }
+
 // Forward request for length
 func (p ReportLatencySlice) Len() int {
     return len(p)
@@ -188,3 +758,113 @@ func (p ReportLatencySlice) Swap(i, j int) {
     p[i], p[j] = p[j], p[i]
 }
 
+// The latency(in seconds) at the given percentile, the slice must be sorted.
+func (p ReportLatencySlice) percentile(percent float64) float64 {
+    if len(p) == 0 {
+        return 0
+    }
+    rank := int(math.Ceil(percent / 100 * float64(len(p)))) - 1
+    if rank < 0 {
+        rank = 0
+    }
+    if rank >= len(p) {
+        rank = len(p) - 1
+    }
+    return p[rank].Seconds()
+}
+
+// The standard deviation(in seconds) of the latencies around mean
+func (p ReportLatencySlice) stdDev(mean float64) float64 {
+    if len(p) == 0 {
+        return 0
+    }
+    sum := float64(0)
+    for _, l := range p {
+        d := l.Seconds() - mean
+        sum += d * d
+    }
+    return math.Sqrt(sum / float64(len(p)))
+}
+
+// The latency percentiles(in seconds) of a histogram
+func percentilesOf(h *Histogram) *LatencyPercentiles {
+    at := func(percent float64) float64 {
+        return time.Duration(h.ValueAt(percent)).Seconds()
+    }
+    return &LatencyPercentiles{
+        P50: at(50),
+        P75: at(75),
+        P90: at(90),
+        P95: at(95),
+        P99: at(99),
+        P999: at(99.9),
+    }
+}
+
+// Split the latencies recorded by a histogram into n buckets of equal width between min and max.
+func histogramOf(h *Histogram, n int) []*HistogramBucket {
+    if h.Count() == 0 || n <= 0 {
+        return nil
+    }
+    min, max := time.Duration(h.Min()).Seconds(), time.Duration(h.Max()).Seconds()
+    width := (max - min) / float64(n)
+    buckets := make([]*HistogramBucket, n)
+    for i := range buckets {
+        buckets[i] = &HistogramBucket{
+            LowerBound: min + width * float64(i),
+            UpperBound: min + width * float64(i + 1),
+        }
+    }
+    buckets[n - 1].UpperBound = max
+    i := 0
+    h.ForEach(func(value int64, count uint64) {
+        for i < n - 1 && time.Duration(value).Seconds() >= buckets[i].UpperBound {
+            i++
+        }
+        buckets[i].Count += int(count)
+    })
+    return buckets
+}
+
+// Split the latencies into n buckets of equal width between min and max, the slice must be sorted.
+func (p ReportLatencySlice) histogram(n int) []*HistogramBucket {
+    if len(p) == 0 || n <= 0 {
+        return nil
+    }
+    min, max := p[0].Seconds(), p[len(p) - 1].Seconds()
+    width := (max - min) / float64(n)
+    buckets := make([]*HistogramBucket, n)
+    for i := range buckets {
+        buckets[i] = &HistogramBucket{
+            LowerBound: min + width * float64(i),
+            UpperBound: min + width * float64(i + 1),
+        }
+    }
+    buckets[n - 1].UpperBound = max
+    i := 0
+    for _, l := range p {
+        for i < n - 1 && l.Seconds() >= buckets[i].UpperBound {
+            i++
+        }
+        buckets[i].Count++
+    }
+    return buckets
+}
+
+// Sort errors by count, the most first
+type errorCounts []*ErrorCount
+
+func (p errorCounts) Len() int {
+    return len(p)
+}
+
+func (p errorCounts) Less(i, j int) bool {
+    if p[i].Count != p[j].Count {
+        return p[i].Count > p[j].Count
+    }
+    return p[i].Kind + p[i].Error < p[j].Kind + p[j].Error
+}
+
+func (p errorCounts) Swap(i, j int) {
+    p[i], p[j] = p[j], p[i]
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A target of many may get no hits at all, eg. -n 1 with two targets
+func TestReportTargetWithoutHits(t *testing.T) {
+    a, b := NewTarget("http://localhost/a"), NewTarget("http://localhost/b")
+    targets, err := NewTargets([]*Target{a, b}, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    collector := NewCollector(false, targets, time.Second, nil)
+    start := time.Now()
+    collector.collectDamage(&Damage{
+        Target: a.Name(),
+        StatusCode: 200,
+        Timestamp: start,
+        StartTime: start,
+        EndTime: start.Add(2 * time.Millisecond),
+        Latency: 2 * time.Millisecond,
+    })
+    report := newReport(&BoomOptions{RequestGoroutines: 1, TotalRequests: 1}, collector, targets, nil)
+    if report == nil {
+        t.Fatal("no report")
+    }
+    if len(report.Targets) != 2 {
+        t.Fatalf("%d targets reported, want 2", len(report.Targets))
+    }
+    idle := report.Targets[1]
+    if idle.Target != b.Name() || idle.CompletedRequests != 0 || idle.LatencyPercentiles == nil {
+        t.Errorf("target without hits = %+v", idle)
+    }
+
+    tests := []struct {
+        format string
+        write  func(w *bytes.Buffer) error
+    }{
+        {formatText, func(w *bytes.Buffer) error {
+            report.prettyPrint(w)
+            return nil
+        }},
+        {formatJSON, func(w *bytes.Buffer) error {
+            return writeJSON(w, report)
+        }},
+        {formatCSV, func(w *bytes.Buffer) error {
+            return writeCSV(w, report)
+        }},
+    }
+    for _, tt := range tests {
+        var buf bytes.Buffer
+        if err := tt.write(&buf); err != nil {
+            t.Errorf("%s: %s", tt.format, err)
+            continue
+        }
+        if !strings.Contains(buf.String(), b.Name()) {
+            t.Errorf("%s: target without hits is missing:\n%s", tt.format, buf.String())
+        }
+    }
+
+    var buf bytes.Buffer
+    if err := writeJSON(&buf, report); err != nil {
+        t.Fatal(err)
+    }
+    var decoded Report
+    if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+        t.Fatal(err)
+    }
+    if p := decoded.Targets[1].LatencyPercentiles; p == nil || p.P99 != 0 {
+        t.Errorf("percentiles of target without hits = %+v", p)
+    }
+}
+
+func TestReportFormatOf(t *testing.T) {
+    tests := []struct {
+        file string
+        want string
+    }{
+        {"report.json", formatJSON},
+        {"out/REPORT.JSON", formatJSON},
+        {"report.csv", formatCSV},
+        {"report.txt", formatText},
+        {"report", formatText},
+        {"report.json.bak", formatText},
+    }
+    for _, tt := range tests {
+        if got := reportFormatOf(tt.file); got != tt.want {
+            t.Errorf("reportFormatOf(%s) = %s, want %s", tt.file, got, tt.want)
+        }
+    }
+}
+
+// A report of the kinds of fields flattened into CSV
+type flattenedReport struct {
+    Count    int                `json:"count"`
+    Rate     float64            `json:"rate,omitempty"`
+    Started  time.Time          `json:"started"`
+    Server   *flattenedServer   `json:"server"`
+    Missing  *flattenedServer   `json:"missing"`
+    Codes    map[int]int        `json:"codes"`
+    Errors   map[string]int     `json:"errors"`
+    Stages   []flattenedServer  `json:"stages"`
+    Untagged string
+    Skipped  string             `json:"-"`
+    hidden   string
+}
+
+type flattenedServer struct {
+    URL  string `json:"url"`
+    Port int    `json:"port"`
+}
+
+func TestFlattenFields(t *testing.T) {
+    r := &flattenedReport{
+        Count: 3,
+        Rate: 1.5,
+        Started: time.Date(2026, 10, 18, 8, 0, 0, 500, time.UTC),
+        Server: &flattenedServer{"http://localhost", 80},
+        Codes: map[int]int{503: 1, 200: 2},
+        Errors: map[string]int{"timeout": 1, "refused": 4},
+        Stages: []flattenedServer{{"a", 1}, {"b", 2}},
+        Untagged: "u",
+        Skipped: "s",
+        hidden: "h",
+    }
+    want := [][]string{
+        {"count", "3"},
+        {"rate", "1.5"},
+        {"started", "2026-10-18T08:00:00.0000005Z"},
+        {"server.url", "http://localhost"},
+        {"server.port", "80"},
+        {"codes.200", "2"},
+        {"codes.503", "1"},
+        {"errors.refused", "4"},
+        {"errors.timeout", "1"},
+        {"stages.0.url", "a"},
+        {"stages.0.port", "1"},
+        {"stages.1.url", "b"},
+        {"stages.1.port", "2"},
+        {"Untagged", "u"},
+    }
+    got := flattenFields("", reflect.ValueOf(r))
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("flattenFields =\n%v\nwant\n%v", got, want)
+    }
+}
+
+// The report file is in the format of -format, or picked by the extension, the same every time it's written
+func TestWriteReportFile(t *testing.T) {
+    r := &flattenedReport{Count: 2, Codes: map[int]int{200: 1, 404: 1}, Server: &flattenedServer{"http://a", 8080}}
+    text := func(w io.Writer) {
+        fmt.Fprintf(w, "Count: %d\n", r.Count)
+    }
+    jsonReport := `{
+  "count": 2,
+  "started": "0001-01-01T00:00:00Z",
+  "server": {
+    "url": "http://a",
+    "port": 8080
+  },
+  "missing": null,
+  "codes": {
+    "200": 1,
+    "404": 1
+  },
+  "errors": null,
+  "stages": null,
+  "Untagged": ""
+}
+`
+    csvReport := "metric,value\ncount,2\nrate,0\nstarted,0001-01-01T00:00:00Z\nserver.url,http://a\nserver.port,8080\n" +
+        "codes.200,1\ncodes.404,1\nUntagged,\n"
+    tests := []struct {
+        file   string
+        format string
+        want   string
+        err    error
+    }{
+        {"report.json", "", jsonReport, nil},
+        {"report.csv", "", csvReport, nil},
+        {"report.txt", "", "Count: 2\n", nil},
+        {"report", "", "Count: 2\n", nil},
+        {"report.txt", formatJSON, jsonReport, nil},
+        {"report.json", formatCSV, csvReport, nil},
+        {"report.csv", formatText, "Count: 2\n", nil},
+        {"report.json", "xml", "", errReportFormat},
+    }
+    dir := t.TempDir()
+    for _, tt := range tests {
+        file := filepath.Join(dir, tt.file)
+        for i := 0; i < 2; i++ {
+            if err := writeReportFile(file, tt.format, r, text); err != tt.err {
+                t.Fatalf("%s as %q: error %v, want %v", tt.file, tt.format, err, tt.err)
+            }
+            if tt.err != nil {
+                break
+            }
+            got, err := os.ReadFile(file)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(got) != tt.want {
+                t.Errorf("%s as %q, write %d:\n%s\nwant\n%s", tt.file, tt.format, i, got, tt.want)
+            }
+        }
+    }
+    if err := writeReportFile(filepath.Join(dir, "no", "such", "dir.json"), "", r, text); err == nil {
+        t.Error("no error for a file in a missing directory")
+    }
+}
+
+// A report written as CSV twice is the same, with the metrics named by the json tags
+func TestReportWriteToFile(t *testing.T) {
+    a, b := NewTarget("http://localhost/a"), NewTarget("http://localhost/b")
+    targets, err := NewTargets([]*Target{a, b}, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    collector := NewCollector(false, targets, 0, nil)
+    start := time.Now()
+    for i, code := range []int{200, 200, 503, 404} {
+        target := a
+        if i % 2 == 1 {
+            target = b
+        }
+        damage := &Damage{Target: target.Name(), StatusCode: code, Proto: "HTTP/1.1", Timestamp: start,
+            StartTime: start, EndTime: start.Add(time.Duration(i + 1) * time.Millisecond),
+            Latency: time.Duration(i + 1) * time.Millisecond}
+        if code != 200 {
+            damage.Error = "status " + http.StatusText(code)
+            damage.ErrorKind = errKindStatus
+        }
+        collector.collectDamage(damage)
+    }
+    report := newReport(&BoomOptions{RequestGoroutines: 2, TotalRequests: 4}, collector, targets, nil)
+    dir := t.TempDir()
+    var written []string
+    for i := 0; i < 2; i++ {
+        file := filepath.Join(dir, fmt.Sprintf("report%d.csv", i))
+        if err := report.writeToFile(file, ""); err != nil {
+            t.Fatal(err)
+        }
+        data, _ := os.ReadFile(file)
+        written = append(written, string(data))
+    }
+    if written[0] != written[1] {
+        t.Errorf("the CSV differs when written again:\n%s\n%s", written[0], written[1])
+    }
+    for _, row := range []string{"metric,value\n", "\ncompleted_requests,4\n", "\nfailed_requests,2\n",
+        "\nlatency_percentiles.p50,", "\nstatus_codes.200,2\n", "\nstatus_codes.404,1\n", "\nstatus_codes.503,1\n",
+        "\nstatus_classes.2xx,2\n", "\nprotocols.HTTP/1.1,4\n", "\ntargets.1.target,GET http://localhost/b\n",
+        "\ntargets.0.failed_requests,1\n"} {
+        if !strings.Contains(written[0], row) {
+            t.Errorf("no %q in the CSV:\n%s", row, written[0])
+        }
+    }
+    // Status codes are in order
+    if strings.Index(written[0], "status_codes.404") > strings.Index(written[0], "status_codes.503") {
+        t.Errorf("status codes out of order:\n%s", written[0])
+    }
+}
+
+// Latencies of the milliseconds, sorted
+func latenciesOf(ms ...float64) ReportLatencySlice {
+    p := make(ReportLatencySlice, len(ms))
+    for i, v := range ms {
+        p[i] = time.Duration(v * float64(time.Millisecond))
+    }
+    return p
+}
+
+// Nearest rank: the smallest latency which at least percent of the latencies are less than or equal to
+func TestReportLatencyPercentile(t *testing.T) {
+    ten := latenciesOf(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+    tests := []struct {
+        p       ReportLatencySlice
+        percent float64
+        want    float64
+    }{
+        {nil, 50, 0},
+        {latenciesOf(7), 0, 0.007},
+        {latenciesOf(7), 50, 0.007},
+        {latenciesOf(7), 100, 0.007},
+        {ten, 0, 0.001},
+        {ten, 10, 0.001},
+        {ten, 11, 0.002},
+        {ten, 50, 0.005},
+        {ten, 90, 0.009},
+        {ten, 99, 0.010},
+        {ten, 100, 0.010},
+        {latenciesOf(3, 3, 3), 99.9, 0.003},
+    }
+    for _, tt := range tests {
+        if got := tt.p.percentile(tt.percent); math.Abs(got - tt.want) > 1e-12 {
+            t.Errorf("percentile %v of %v = %v, want %v", tt.percent, tt.p, got, tt.want)
+        }
+    }
+}
+
+func TestReportLatencyStdDev(t *testing.T) {
+    tests := []struct {
+        p    ReportLatencySlice
+        mean float64
+        want float64
+    }{
+        {nil, 0, 0},
+        {latenciesOf(5), 0.005, 0},
+        {latenciesOf(4, 4, 4, 4), 0.004, 0},
+        {latenciesOf(2, 4, 4, 4, 5, 5, 7, 9), 0.005, 0.002},
+    }
+    for _, tt := range tests {
+        if got := tt.p.stdDev(tt.mean); math.Abs(got - tt.want) > 1e-12 {
+            t.Errorf("stdDev of %v = %v, want %v", tt.p, got, tt.want)
+        }
+    }
+}
+
+// Buckets of equal width between min and max, the last one includes max
+func TestReportLatencyHistogram(t *testing.T) {
+    tests := []struct {
+        name   string
+        p      ReportLatencySlice
+        n      int
+        counts []int
+        lower  float64
+        upper  float64
+    }{
+        {"empty", nil, 3, nil, 0, 0},
+        {"no buckets", latenciesOf(1, 2), 0, nil, 0, 0},
+        {"one latency", latenciesOf(5), 3, []int{0, 0, 1}, 0.005, 0.005},
+        {"all equal", latenciesOf(2, 2, 2, 2), 2, []int{0, 4}, 0.002, 0.002},
+        {"one bucket", latenciesOf(1, 5, 9), 1, []int{3}, 0.001, 0.009},
+        {"spread", latenciesOf(1, 1.5, 2, 5, 9.5, 10, 10, 10), 3, []int{3, 1, 4}, 0.001, 0.010},
+    }
+    for _, tt := range tests {
+        buckets := tt.p.histogram(tt.n)
+        if len(buckets) != len(tt.counts) {
+            t.Errorf("%s: %d buckets, want %d", tt.name, len(buckets), len(tt.counts))
+            continue
+        }
+        for i, b := range buckets {
+            if b.Count != tt.counts[i] {
+                t.Errorf("%s: bucket %d has %d, want %d", tt.name, i, b.Count, tt.counts[i])
+            }
+        }
+        if len(buckets) > 0 && (buckets[0].LowerBound != tt.lower || buckets[len(buckets) - 1].UpperBound != tt.upper) {
+            t.Errorf("%s: from %v to %v, want %v to %v", tt.name, buckets[0].LowerBound,
+                buckets[len(buckets) - 1].UpperBound, tt.lower, tt.upper)
+        }
+    }
+}
+
+// The histogram of a latency histogram is split the same as the one of the latencies
+func TestHistogramOf(t *testing.T) {
+    tests := []struct {
+        name   string
+        ms     []float64
+        n      int
+        counts []int
+    }{
+        {"empty", nil, 3, nil},
+        {"one latency", []float64{5}, 3, []int{0, 0, 1}},
+        {"all equal", []float64{2, 2, 2}, 2, []int{0, 3}},
+        {"spread", []float64{1, 1.5, 2, 5, 9.5, 10, 10, 10}, 3, []int{3, 1, 4}},
+    }
+    for _, tt := range tests {
+        h := NewLatencyHistogram()
+        for _, l := range latenciesOf(tt.ms...) {
+            h.RecordDuration(l)
+        }
+        buckets := histogramOf(h, tt.n)
+        if len(buckets) != len(tt.counts) {
+            t.Errorf("%s: %d buckets, want %d", tt.name, len(buckets), len(tt.counts))
+            continue
+        }
+        for i, b := range buckets {
+            if b.Count != tt.counts[i] {
+                t.Errorf("%s: bucket %d has %d, want %d", tt.name, i, b.Count, tt.counts[i])
+            }
+        }
+    }
+}
+
+func TestPrintHistogram(t *testing.T) {
+    tests := []struct {
+        name    string
+        buckets []*HistogramBucket
+        want    string
+    }{
+        {"empty", nil, ""},
+        {"bars", []*HistogramBucket{{0.001, 0.002, 4}, {0.002, 0.003, 0}, {0.003, 0.004, 2}, {0.004, 0.005, 1}},
+            "       2.000ms [4]\t|" + strings.Repeat("■", 40) + "\n" +
+            "       3.000ms [0]\t|\n" +
+            "       4.000ms [2]\t|" + strings.Repeat("■", 20) + "\n" +
+            "       5.000ms [1]\t|" + strings.Repeat("■", 10) + "\n"},
+        {"no counts", []*HistogramBucket{{0.001, 0.001, 0}}, "       1.000ms [0]\t|\n"},
+    }
+    for _, tt := range tests {
+        var buf bytes.Buffer
+        printHistogram(&buf, tt.buckets)
+        if buf.String() != tt.want {
+            t.Errorf("%s: printHistogram =\n%q\nwant\n%q", tt.name, buf.String(), tt.want)
+        }
+    }
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Most requests in flight on a pipelined connection, sending more waits for the responses
+const maxPipelined = 1024
+
+// Options of the socket engine
+type SocketOptions struct {
+    Hex         bool          // The body is hex, whitespaces are ignored
+    Conns       int           // Persistent TCP connections the requests are pipelined on, 0 for a connection each request
+    ReadTimeout time.Duration // How long to wait for a response, the timeout of requests if it's 0
+    NoReply     bool          // Don't wait for responses, the latency is the time to send
+}
+
+// socketEngine sends the body as a payload over raw TCP or UDP, the target URL is like tcp://host:port or
+// udp://host:port. A response is complete when it matches the body regex or contains the text of the expectation,
+// or when a read returns without them: a datagram of UDP, or whatever has arrived over TCP.
+type socketEngine struct {
+    ctrl  *CtrlCenter
+    dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+    opts  SocketOptions
+    conns []*pipelinedConn // Persistent TCP connections, nil if there is a connection each request
+    next  uint64
+}
+
+// Create the socket engine
+func newSocketEngine(ct *CtrlCenter, dial func(ctx context.Context, network, addr string) (net.Conn, error),
+    opts SocketOptions) (Engine, error) {
+
+    if opts.ReadTimeout <= 0 {
+        opts.ReadTimeout = ct.Timeout
+    }
+    e := &socketEngine{ctrl: ct, dial: dial, opts: opts}
+    for i := 0; i < opts.Conns; i++ {
+        e.conns = append(e.conns, &pipelinedConn{engine: e})
+    }
+    return e, nil
+}
+
+// Send the payload and read the response
+func (e *socketEngine) Hit(req *http.Request, damage *Damage) {
+    payload, err := e.payload(req)
+    if err != nil {
+        damage.setError(err)
+        return
+    }
+    network := req.URL.Scheme
+    if network != "tcp" && network != "udp" || req.URL.Port() == "" {
+        damage.setError(errSocketURL)
+        return
+    }
+    damage.Proto = strings.ToUpper(network)
+    damage.SentBytes = uint64(len(payload))
+
+    var resp []byte
+    if network == "tcp" && e.conns != nil {
+        n := atomic.AddUint64(&e.next, 1) - 1
+        resp, err = e.conns[n % uint64(len(e.conns))].send(req.URL.Host, payload, damage)
+    } else {
+        resp, err = e.send(network, req.URL.Host, payload, damage)
+    }
+    damage.EndTime = time.Now()
+    damage.Latency = damage.EndTime.Sub(damage.StartTime)
+    if err != nil {
+        damage.setError(err)
+        if _, ok := err.(*socketMatchError); ok {
+            damage.ErrorKind = errKindExpectBody
+        }
+        return
+    }
+    damage.ReceivedBytes = uint64(len(resp))
+    if e.opts.NoReply {
+        return
+    }
+    if kind, msg := e.ctrl.Expect.checkBody(resp); kind != "" {
+        damage.Error = msg
+        damage.ErrorKind = kind
+    }
+}
+
+// The payload rendered from the body of the request
+func (e *socketEngine) payload(req *http.Request) ([]byte, error) {
+    if req.Body == nil {
+        return nil, nil
+    }
+    body, err := ioutil.ReadAll(req.Body)
+    req.Body.Close()
+    if err != nil || !e.opts.Hex {
+        return body, err
+    }
+    payload, err := hex.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+    if err != nil {
+        return nil, fmt.Errorf("not valid hex payload: %s", err)
+    }
+    return payload, nil
+}
+
+// Send the payload over a connection of its own, then close it.
+func (e *socketEngine) send(network, addr string, payload []byte, damage *Damage) ([]byte, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), e.ctrl.Timeout)
+    defer cancel()
+    damage.StartTime = time.Now()
+    conn, err := e.dial(ctx, network, addr)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    if network == "tcp" {
+        damage.Connect = time.Since(damage.StartTime)
+    }
+
+    conn.SetWriteDeadline(time.Now().Add(e.ctrl.Timeout))
+    if _, err := conn.Write(payload); err != nil {
+        return nil, err
+    }
+    if e.opts.NoReply {
+        return nil, nil
+    }
+    conn.SetReadDeadline(time.Now().Add(e.opts.ReadTimeout))
+    resp, _, err := e.readResponse(conn, nil)
+    return resp, err
+}
+
+// Read a response after the data left by the last one, returns the response and the data after it.
+func (e *socketEngine) readResponse(conn net.Conn, data []byte) ([]byte, []byte, error) {
+    buf := make([]byte, 64 << 10)
+    for {
+        if len(data) > 0 {
+            if end := e.responseEnd(data); end > 0 {
+                return data[:end], data[end:], nil
+            }
+        }
+        if len(data) > maxCheckedBody {
+            return nil, nil, errSocketResponseSize
+        }
+        n, err := conn.Read(buf)
+        data = append(data, buf[:n]...)
+        if err != nil {
+            // Something arrived, but not the response expected
+            if len(data) > 0 {
+                return nil, nil, &socketMatchError{err}
+            }
+            return nil, nil, err
+        }
+    }
+}
+
+// Where the response ends in the data, 0 if it's not complete yet.
+func (e *socketEngine) responseEnd(data []byte) int {
+    expect := e.ctrl.Expect
+    switch {
+    case expect.BodyRegex != nil:
+        if loc := expect.BodyRegex.FindIndex(data); loc != nil && loc[1] > 0 {
+            return loc[1]
+        }
+        return 0
+    case expect.BodyContains != "":
+        if i := bytes.Index(data, []byte(expect.BodyContains)); i >= 0 {
+            return i + len(expect.BodyContains)
+        }
+        return 0
+    }
+    return len(data)
+}
+
+// The response expected didn't arrive before the connection is broken, eg. by the read timeout
+type socketMatchError struct {
+    err error
+}
+
+func (e *socketMatchError) Error() string {
+    if netErr, ok := e.err.(net.Error); ok && netErr.Timeout() {
+        return "no response expected before the read timeout"
+    }
+    return "no response expected before " + e.err.Error()
+}
+
+// A persistent TCP connection, requests are written one after another without waiting for the responses,
+// and the responses are read in the same order. It's dialed again for the next request if it's broken.
+type pipelinedConn struct {
+    engine  *socketEngine
+    mu      sync.Mutex // Guards the writes and the calls, so they are in the same order
+    conn    net.Conn
+    calls   chan *socketCall
+    err     error
+}
+
+// A request waiting for its response on a pipelined connection
+type socketCall struct {
+    deadline time.Time
+    resp     []byte
+    err      error
+    done     chan struct{}
+}
+
+func (call *socketCall) fail(err error) {
+    call.err = err
+    close(call.done)
+}
+
+// Write the payload and wait for its response
+func (c *pipelinedConn) send(addr string, payload []byte, damage *Damage) ([]byte, error) {
+    e := c.engine
+    call := &socketCall{done: make(chan struct{})}
+    c.mu.Lock()
+    if c.conn == nil || c.err != nil {
+        ctx, cancel := context.WithTimeout(context.Background(), e.ctrl.Timeout)
+        start := time.Now()
+        conn, err := e.dial(ctx, "tcp", addr)
+        cancel()
+        if err != nil {
+            c.mu.Unlock()
+            damage.StartTime = start
+            return nil, err
+        }
+        damage.Connect = time.Since(start)
+        c.conn, c.err = conn, nil
+        c.calls = make(chan *socketCall, maxPipelined)
+        if !e.opts.NoReply {
+            go c.readResponses(conn, c.calls)
+        }
+    } else {
+        damage.ConnReused = true
+    }
+    damage.StartTime = time.Now()
+    call.deadline = damage.StartTime.Add(e.opts.ReadTimeout)
+    c.conn.SetWriteDeadline(time.Now().Add(e.ctrl.Timeout))
+    if _, err := c.conn.Write(payload); err != nil {
+        c.broken(err)
+        c.mu.Unlock()
+        return nil, err
+    }
+    if e.opts.NoReply {
+        c.mu.Unlock()
+        return nil, nil
+    }
+    c.calls <- call
+    c.mu.Unlock()
+    <-call.done
+    return call.resp, call.err
+}
+
+// Read the responses of the calls in order, the calls left fail if the connection is broken. It returns when the
+// calls are closed, as the connection is broken by a writer.
+func (c *pipelinedConn) readResponses(conn net.Conn, calls chan *socketCall) {
+    var data []byte
+    for call := range calls {
+        conn.SetReadDeadline(call.deadline)
+        call.resp, data, call.err = c.engine.readResponse(conn, data)
+        close(call.done)
+        if call.err == nil {
+            continue
+        }
+        // The lock may be held by a writer waiting for a free place in the calls, so they are failed meanwhile
+        conn.Close()
+        marked := make(chan struct{})
+        go func(err error) {
+            c.mu.Lock()
+            if c.conn == conn {
+                c.err = err
+            }
+            c.mu.Unlock()
+            close(marked)
+        }(call.err)
+        for {
+            select {
+            case call, ok := <-calls:
+                if !ok {
+                    return
+                }
+                call.fail(errSocketBroken)
+            case <-marked:
+                // No more calls after it's marked broken
+                for {
+                    select {
+                    case call, ok := <-calls:
+                        if !ok {
+                            return
+                        }
+                        call.fail(errSocketBroken)
+                    default:
+                        return
+                    }
+                }
+            }
+        }
+    }
+}
+
+// Close the connection as it's broken, it must be called with the lock held. The calls are closed so the reader
+// of the connection returns, the next request dials a connection with calls of its own.
+func (c *pipelinedConn) broken(err error) {
+    c.err = err
+    c.conn.Close()
+    close(c.calls)
+}
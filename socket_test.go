@@ -0,0 +1,256 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "regexp"
+    "runtime"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// A TCP server answering each line with OK and the line, counting the connections
+func startLineServer(t *testing.T, accepted *int64) string {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            atomic.AddInt64(accepted, 1)
+            go func() {
+                defer conn.Close()
+                r := bufio.NewReader(conn)
+                for {
+                    line, err := r.ReadString('\n')
+                    if err != nil {
+                        return
+                    }
+                    conn.Write([]byte("OK " + line))
+                }
+            }()
+        }
+    }()
+    return l.Addr().String()
+}
+
+func socketTestEngine(t *testing.T, opts SocketOptions, expect *Expectation,
+    dial func(ctx context.Context, network, addr string) (net.Conn, error)) Engine {
+
+    ct := NewDefaultCtrlCenter()
+    ct.Timeout = 2 * time.Second
+    if expect != nil {
+        ct.Expect = expect
+    }
+    if dial == nil {
+        dial = (&net.Dialer{}).DialContext
+    }
+    e, err := newSocketEngine(ct, dial, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return e
+}
+
+func socketHit(e Engine, url, body string) *Damage {
+    req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+    damage := &Damage{}
+    e.Hit(req, damage)
+    return damage
+}
+
+func TestSocketResponseEnd(t *testing.T) {
+    tests := []struct {
+        expect *Expectation
+        data   string
+        want   int
+    }{
+        {&Expectation{}, "anything", 8},
+        {&Expectation{BodyContains: "\r\n"}, "OK 1\r\nOK 2\r\n", 6},
+        {&Expectation{BodyContains: "\r\n"}, "OK 1", 0},
+        {&Expectation{BodyRegex: regexp.MustCompile(`END\n`)}, "a\nb\nEND\nc", 8},
+        {&Expectation{BodyRegex: regexp.MustCompile(`END\n`)}, "a\nb\n", 0},
+        // An empty match doesn't end a response
+        {&Expectation{BodyRegex: regexp.MustCompile(`x*`)}, "abc", 0},
+    }
+    for _, tt := range tests {
+        ct := NewDefaultCtrlCenter()
+        ct.Expect = tt.expect
+        e := &socketEngine{ctrl: ct}
+        if got := e.responseEnd([]byte(tt.data)); got != tt.want {
+            t.Errorf("responseEnd(%q) = %d, want %d", tt.data, got, tt.want)
+        }
+    }
+}
+
+func TestSocketPayload(t *testing.T) {
+    tests := []struct {
+        hex  bool
+        body string
+        want []byte
+        err  bool
+    }{
+        {false, "stats\r\n", []byte("stats\r\n"), false},
+        {true, "de ad\n\tBE EF", []byte{0xde, 0xad, 0xbe, 0xef}, false},
+        {true, "abc", nil, true},
+        {true, "zz", nil, true},
+    }
+    for _, tt := range tests {
+        e := &socketEngine{opts: SocketOptions{Hex: tt.hex}}
+        req, _ := http.NewRequest(http.MethodPost, "tcp://127.0.0.1:1", strings.NewReader(tt.body))
+        got, err := e.payload(req)
+        if (err != nil) != tt.err || !tt.err && !bytes.Equal(got, tt.want) {
+            t.Errorf("payload(%q) = %x, %v, want %x", tt.body, got, err, tt.want)
+        }
+    }
+}
+
+// Requests concurrently over a connection each or pipelined on a few, the responses must be in order
+func TestSocketEngineTCP(t *testing.T) {
+    tests := []struct {
+        conns    int
+        accepted int64 // Connections the server accepts
+    }{
+        {0, 40},
+        {1, 1},
+        {3, 3},
+    }
+    for _, tt := range tests {
+        var accepted int64
+        url := "tcp://" + startLineServer(t, &accepted)
+        e := socketTestEngine(t, SocketOptions{Conns: tt.conns}, &Expectation{BodyContains: "\n"}, nil)
+        var wg sync.WaitGroup
+        var reused int64
+        for i := 0; i < 40; i++ {
+            wg.Add(1)
+            go func(i int) {
+                defer wg.Done()
+                line := strings.Repeat("x", i) + "\n"
+                damage := socketHit(e, url, line)
+                if damage.Error != "" || damage.Proto != "TCP" || damage.ReceivedBytes != uint64(len(line) + 3) {
+                    t.Errorf("%d conns: hit %d: error %q, proto %s, received %d", tt.conns, i, damage.Error,
+                        damage.Proto, damage.ReceivedBytes)
+                }
+                if damage.ConnReused {
+                    atomic.AddInt64(&reused, 1)
+                }
+            }(i)
+        }
+        wg.Wait()
+        if accepted != tt.accepted {
+            t.Errorf("%d conns: %d connections accepted, want %d", tt.conns, accepted, tt.accepted)
+        }
+        if tt.conns > 0 && reused != 40 - tt.accepted {
+            t.Errorf("%d conns: %d hits reused a connection", tt.conns, reused)
+        }
+    }
+}
+
+func TestSocketEngineExpect(t *testing.T) {
+    var accepted int64
+    url := "tcp://" + startLineServer(t, &accepted)
+    tests := []struct {
+        expect *Expectation
+        kind   string
+        err    string
+    }{
+        {&Expectation{BodyRegex: regexp.MustCompile(`^OK .*\n`)}, "", ""},
+        {&Expectation{BodyContains: "ERROR"}, errKindExpectBody, "no response expected before the read timeout"},
+    }
+    for _, tt := range tests {
+        e := socketTestEngine(t, SocketOptions{Conns: 1, ReadTimeout: 200 * time.Millisecond}, tt.expect, nil)
+        damage := socketHit(e, url, "get\n")
+        if damage.ErrorKind != tt.kind || damage.Error != tt.err {
+            t.Errorf("expect %v: error %q %q, want %q %q", tt.expect, damage.ErrorKind, damage.Error, tt.kind, tt.err)
+        }
+    }
+}
+
+func TestSocketEngineUDP(t *testing.T) {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pc.Close()
+    go func() {
+        buf := make([]byte, 1500)
+        for {
+            n, addr, err := pc.ReadFrom(buf)
+            if err != nil {
+                return
+            }
+            pc.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+        }
+    }()
+    url := "udp://" + pc.LocalAddr().String()
+    e := socketTestEngine(t, SocketOptions{Conns: 2}, nil, nil)
+    for i := 0; i < 5; i++ {
+        damage := socketHit(e, url, "ping")
+        if damage.Error != "" || damage.Proto != "UDP" || damage.ReceivedBytes != 9 || damage.ConnReused {
+            t.Errorf("hit %d: error %q, proto %s, received %d, reused %v", i, damage.Error, damage.Proto,
+                damage.ReceivedBytes, damage.ConnReused)
+        }
+    }
+    if damage := socketHit(e, "udp://127.0.0.1", "ping"); damage.Error != errSocketURL.Error() {
+        t.Errorf("no port: error %q", damage.Error)
+    }
+}
+
+// A connection failing every other write
+type flakyConn struct {
+    net.Conn
+    writes *int64
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+    if atomic.AddInt64(c.writes, 1) % 2 == 0 {
+        return 0, errors.New("write failed")
+    }
+    return c.Conn.Write(b)
+}
+
+// Pipelined connections broken by a write are dialed again, and their readers return
+func TestSocketEngineReconnect(t *testing.T) {
+    var accepted, writes int64
+    url := "tcp://" + startLineServer(t, &accepted)
+    dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+        conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+        if err != nil {
+            return nil, err
+        }
+        return &flakyConn{conn, &writes}, nil
+    }
+    e := socketTestEngine(t, SocketOptions{Conns: 1}, &Expectation{BodyContains: "\n"}, dial)
+
+    socketHit(e, url, "warm up\n")
+    before := runtime.NumGoroutine()
+    for i := 0; i < 40; i++ {
+        damage := socketHit(e, url, "get\n")
+        if want := i % 2 == 0; (damage.Error != "") != want {
+            t.Errorf("hit %d: error %q, want an error %v", i, damage.Error, want)
+        }
+    }
+    if accepted != 21 {
+        t.Errorf("%d connections accepted, want 21", accepted)
+    }
+    // The readers and the server side of the broken connections return
+    deadline := time.Now().Add(2 * time.Second)
+    for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if n := runtime.NumGoroutine(); n > before {
+        t.Errorf("%d goroutines after reconnecting, %d before", n, before)
+    }
+}
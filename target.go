@@ -3,9 +3,10 @@ package main
 import (
     "regexp"
     "net/http"
-    "strings"
-    "time"
     "bytes"
+    "io"
+    "io/ioutil"
+    "os"
 )
 
 const (
@@ -15,10 +16,18 @@ const (
 // A target is just a URL with some extra properties.
 type Target struct {
     method string
+    name   string
     Url    string
+    Weight int // Relative share of the traffic among the targets
     Body   []byte
+    // File streamed as body of each request instead of Body, so big files are never held in memory.
+    BodyFile string
+    bodySize int64
     header http.Header
-    cookie http.Cookie
+    cookies []*http.Cookie
+    username string
+    password string
+    tmpl   *targetTemplate // Templates of url, header and body, nil if there is none
 }
 
 // Use to check http methods
@@ -27,7 +36,7 @@ var httpMethodChecker = regexp.MustCompile("^(HEAD|GET|PUT|POST|PATCH|OPTIONS|DE
 
 // Create a target with the specified url.
 func NewTarget(url string) (t *Target) {
-    t = &Target{Url:url, method:defaultMethod, header:http.Header{}}
+    t = &Target{Url:url, method:defaultMethod, Weight:1, header:http.Header{}}
     return t
 }
 
@@ -48,38 +57,104 @@ func (t *Target) AddHeader(key, value string) {
     t.header.Add(key, value)
 }
 
-func (t *Target) SetCookie(key string, value interface{}) {
-    switch strings.ToLower(key) {
-    case "name":
-        t.cookie.Name = value.(string)
-    case "value":
-        t.cookie.Value = value.(string)
-    case "path":
-        t.cookie.Path = value.(string)
-    case "domain":
-        t.cookie.Domain = value.(string)
-    case "expires":
-        exp, _ := time.Parse("ANSIC", value.(string))
-        t.cookie.Expires = exp
-    case "maxage":
-        t.cookie.MaxAge = value.(int)
-    default:
-        panic("Not supported for now. key:" + key + ", value:" + value.(string))
+// The name of target in reports, eg. GET http://localhost/items if it's not set
+func (t *Target) Name() string {
+    if t.name != "" {
+        return t.name
     }
+    return t.method + " " + t.Url
 }
 
+// Set the name of target in reports
+func (t *Target) SetName(name string) {
+    t.name = name
+}
+
+// Use the content of file as body. If stream is true, the file is read from disk for every request,
+// otherwise it's read into Body once.
+func (t *Target) SetBodyFile(file string, stream bool) error {
+    if !stream {
+        body, err := ioutil.ReadFile(file)
+        if err != nil {
+            return err
+        }
+        t.Body = body
+        return nil
+    }
+    info, err := os.Stat(file)
+    if err != nil {
+        return err
+    }
+    if !info.Mode().IsRegular() {
+        return errBodyFile
+    }
+    t.BodyFile = file
+    t.bodySize = info.Size()
+    return nil
+}
+
+// Add a cookie to every request
+func (t *Target) AddCookie(name, value string) {
+    t.cookies = append(t.cookies, &http.Cookie{Name: name, Value: value})
+}
+
+// Use BASIC Authentication for every request
+func (t *Target) SetBasicAuth(username, password string) {
+    t.username, t.password = username, password
+}
+
+// Create a request to the target, the templates are rendered freshly if any.
 func (t *Target) Request() (*http.Request, error) {
-    req, err := http.NewRequest(t.method, t.Url, bytes.NewReader(t.Body))
+    url, header, body := t.Url, t.header, t.Body
+    if t.tmpl != nil {
+        var err error
+        if url, header, body, err = t.tmpl.render(t); err != nil {
+            return nil, err
+        }
+    }
+    if t.BodyFile != "" {
+        return t.streamRequest(url, header)
+    }
+    req, err := http.NewRequest(t.method, url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    return t.prepare(req, header), nil
+}
+
+// Create a request whose body is read from BodyFile, the file is closed by the client after sent.
+func (t *Target) streamRequest(url string, header http.Header) (*http.Request, error) {
+    f, err := os.Open(t.BodyFile)
+    if err != nil {
+        return nil, err
+    }
+    req, err := http.NewRequest(t.method, url, f)
     if err != nil {
+        f.Close()
         return nil, err
     }
-    for k, vs := range t.header {
+    req.ContentLength = t.bodySize
+    // Used to send the body again on redirects and retries
+    req.GetBody = func() (io.ReadCloser, error) {
+        return os.Open(t.BodyFile)
+    }
+    return t.prepare(req, header), nil
+}
+
+// Set headers, cookies and authentication of the request
+func (t *Target) prepare(req *http.Request, header http.Header) *http.Request {
+    for k, vs := range header {
         req.Header[k] = make([]string, len(vs))
         copy(req.Header[k], vs)
     }
-    req.AddCookie(&t.cookie)
+    for _, c := range t.cookies {
+        req.AddCookie(c)
+    }
+    if t.username != "" || t.password != "" {
+        req.SetBasicAuth(t.username, t.password)
+    }
     if host := req.Header.Get("Host"); host != "" {
         req.Host = host
     }
-    return req, nil
+    return req
 }
\ No newline at end of file
@@ -0,0 +1,128 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+)
+
+// A body file larger than a read buffer, of bytes which aren't text
+func writeBodyFile(t *testing.T, size int) (string, []byte) {
+    body := make([]byte, size)
+    rand.New(rand.NewSource(1)).Read(body)
+    file := filepath.Join(t.TempDir(), "body.bin")
+    if err := os.WriteFile(file, body, 0644); err != nil {
+        t.Fatal(err)
+    }
+    return file, body
+}
+
+// The body of -D @@file arrives in full, held in memory or streamed
+func TestCreateTargetBodyFile(t *testing.T) {
+    tests := []struct {
+        size   int
+        stream bool
+    }{
+        {1, false},
+        {100 << 10, false},
+        {1, true},
+        {100 << 10, true},
+    }
+    for _, tt := range tests {
+        file, body := writeBodyFile(t, tt.size)
+        target := createTarget(&BoomOptions{URL: "http://localhost/upload", RequestMethod: "POST",
+            RequestPostData: "@@" + file, RequestPostDataContentType: "application/octet-stream", StreamBody: tt.stream})
+        req, err := target.Request()
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, _ := ioutil.ReadAll(req.Body)
+        req.Body.Close()
+        if !bytes.Equal(got, body) || req.ContentLength != int64(tt.size) {
+            t.Errorf("%d bytes, stream %v: %d bytes read, content length %d", tt.size, tt.stream, len(got), req.ContentLength)
+        }
+        if (target.BodyFile != "") != tt.stream {
+            t.Errorf("%d bytes, stream %v: body file %q", tt.size, tt.stream, target.BodyFile)
+        }
+    }
+}
+
+func TestSetBodyFileErrors(t *testing.T) {
+    target := NewTarget("http://localhost/")
+    for _, stream := range []bool{false, true} {
+        if err := target.SetBodyFile(filepath.Join(t.TempDir(), "missing"), stream); err == nil {
+            t.Errorf("stream %v: no error for a missing file", stream)
+        }
+    }
+    if err := target.SetBodyFile(t.TempDir(), true); err != errBodyFile {
+        t.Errorf("a directory streamed: error %v, want %v", err, errBodyFile)
+    }
+}
+
+// A streamed body is sent again on each hit, and again after a 307 redirect
+func TestTargetStreamRequest(t *testing.T) {
+    file, body := writeBodyFile(t, 300 << 10)
+    var (
+        mu       sync.Mutex
+        received = map[string][]int64{} // Bodies read in full on each path, by their content length
+    )
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got, _ := ioutil.ReadAll(r.Body)
+        if !bytes.Equal(got, body) {
+            http.Error(w, "body differs", http.StatusBadRequest)
+            return
+        }
+        mu.Lock()
+        received[r.URL.Path] = append(received[r.URL.Path], r.ContentLength)
+        mu.Unlock()
+        if r.URL.Path == "/upload" {
+            http.Redirect(w, r, "/stored", http.StatusTemporaryRedirect)
+        }
+    }))
+    defer server.Close()
+
+    target := NewTarget(server.URL + "/upload")
+    target.SetMethod("PUT")
+    if err := target.SetBodyFile(file, true); err != nil {
+        t.Fatal(err)
+    }
+    req, err := target.Request()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.ContentLength != int64(len(body)) || req.GetBody == nil {
+        t.Fatalf("content length %d, GetBody set %v", req.ContentLength, req.GetBody != nil)
+    }
+    again, _ := req.GetBody()
+    got, _ := ioutil.ReadAll(again)
+    again.Close()
+    if !bytes.Equal(got, body) {
+        t.Errorf("GetBody read %d bytes", len(got))
+    }
+    req.Body.Close()
+
+    e := newHTTPEngine(NewDefaultCtrlCenter(), (&net.Dialer{}).DialContext)
+    for i := 0; i < 3; i++ {
+        req, err := target.Request()
+        if err != nil {
+            t.Fatal(err)
+        }
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.Error != "" || damage.StatusCode != http.StatusOK {
+            t.Errorf("hit %d: status %d, error %s", i, damage.StatusCode, damage.Error)
+        }
+    }
+    for _, path := range []string{"/upload", "/stored"} {
+        if lengths := received[path]; len(lengths) != 3 || lengths[0] != int64(len(body)) {
+            t.Errorf("%s received the body %d times, content lengths %v", path, len(lengths), lengths)
+        }
+    }
+}
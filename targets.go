@@ -0,0 +1,212 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math/rand"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Orders to pick the next target
+const (
+    orderRoundRobin = "round-robin"
+    orderRandom = "random"
+)
+
+// Targets is a group of targets, the missile hits them one by one in the specified order.
+// Each target gets the share of hits by its weight.
+type Targets struct {
+    list     []*Target
+    random   bool
+    weighted bool // Whether the weights are not all the same
+    next     uint64
+
+    // Running sums of weights, for weighted random
+    cumWeights  []int
+    totalWeight int
+
+    // Current weights, for smooth weighted round-robin
+    mu             sync.Mutex
+    currentWeights []int
+}
+
+// Create a group of targets picked by order, which is round-robin or random.
+func NewTargets(list []*Target, order string) (*Targets, error) {
+    if len(list) == 0 {
+        return nil, errNoTargets
+    }
+    ts := &Targets{list: list}
+    switch order {
+    case "", orderRoundRobin:
+    case orderRandom:
+        ts.random = true
+    default:
+        return nil, errTargetsOrder
+    }
+    ts.cumWeights = make([]int, len(list))
+    ts.currentWeights = make([]int, len(list))
+    for i, t := range list {
+        if t.Weight <= 0 {
+            return nil, fmt.Errorf("weight of %s must be bigger than zero", t.Name())
+        }
+        if t.Weight != list[0].Weight {
+            ts.weighted = true
+        }
+        ts.totalWeight += t.Weight
+        ts.cumWeights[i] = ts.totalWeight
+    }
+    return ts, nil
+}
+
+// Pick the next target to hit, it's safe to be called by multi goroutines.
+func (ts *Targets) Next() *Target {
+    if len(ts.list) == 1 {
+        return ts.list[0]
+    }
+    if ts.random {
+        if !ts.weighted {
+            return ts.list[rand.Intn(len(ts.list))]
+        }
+        w := rand.Intn(ts.totalWeight)
+        return ts.list[sort.SearchInts(ts.cumWeights, w + 1)]
+    }
+    if !ts.weighted {
+        n := atomic.AddUint64(&ts.next, 1) - 1
+        return ts.list[n % uint64(len(ts.list))]
+    }
+    // Smooth weighted round-robin, the way nginx does. Targets are interleaved rather than
+    // hit in bursts, eg. weights of 5,1,1 give a,a,b,a,c,a,a.
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    best := 0
+    for i, t := range ts.list {
+        ts.currentWeights[i] += t.Weight
+        if ts.currentWeights[i] > ts.currentWeights[best] {
+            best = i
+        }
+    }
+    ts.currentWeights[best] -= ts.totalWeight
+    return ts.list[best]
+}
+
+// Sum of the weights of all targets
+func (ts *Targets) TotalWeight() int {
+    return ts.totalWeight
+}
+
+// All the targets in the group
+func (ts *Targets) List() []*Target {
+    return ts.list
+}
+
+// A line starting a target, a method and an absolute url
+var targetLineChecker = regexp.MustCompile(`^[A-Z]+\s+[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// Read targets from a file in the format of vegeta:
+//
+//     GET http://localhost:8080/items?page=1
+//     X-Account-ID: 8675309
+//
+//     POST http://localhost:8080/cart
+//     Content-Type: application/json
+//     @/path/to/body.json
+//
+// The line of method and url can be followed by options of the target:
+//
+//     GET http://localhost:8080/items weight=70 name=list-items
+//
+// weight is the relative share of the traffic(1 by default), name is the name in reports.
+//
+// Each target starts with a line of method and url, followed by optional header lines
+// and an optional line of '@' and the path of the body file. A line of method and url starts a new target,
+// blank lines between targets are optional, lines start with '#' are comments.
+//
+// Body files are streamed from disk for every request if streamBodies is true.
+func ReadTargets(file string, streamBodies bool) ([]*Target, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return parseTargets(f, streamBodies)
+}
+
+// Parse targets from the reader, see ReadTargets
+func parseTargets(r io.Reader, streamBodies bool) ([]*Target, error) {
+    var (
+        targets = make([]*Target, 0)
+        current *Target
+        lineNo = 0
+    )
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        switch {
+        case strings.HasPrefix(line, "#"):
+            continue
+        case line == "":
+            current = nil
+        case current == nil || targetLineChecker.MatchString(line):
+            tokens := strings.Fields(line)
+            if len(tokens) < 2 {
+                return nil, fmt.Errorf("line %d: expect 'METHOD URL' but got: %s", lineNo, line)
+            }
+            current = NewTarget(tokens[1])
+            if err := current.SetMethod(tokens[0]); err != nil {
+                return nil, fmt.Errorf("line %d: %s %s", lineNo, err, tokens[0])
+            }
+            if err := setTargetOptions(current, tokens[2:]); err != nil {
+                return nil, fmt.Errorf("line %d: %s", lineNo, err)
+            }
+            targets = append(targets, current)
+        case strings.HasPrefix(line, "@"):
+            if err := current.SetBodyFile(strings.TrimPrefix(line, "@"), streamBodies); err != nil {
+                return nil, fmt.Errorf("line %d: %s", lineNo, err)
+            }
+        default:
+            headerValue := strings.SplitN(line, ":", 2)
+            if len(headerValue) != 2 {
+                return nil, fmt.Errorf("line %d: not valid http header: %s", lineNo, line)
+            }
+            current.AddHeader(strings.TrimSpace(headerValue[0]), strings.TrimSpace(headerValue[1]))
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(targets) == 0 {
+        return nil, errNoTargets
+    }
+    return targets, nil
+}
+
+// Set options like weight=70 name=list-items to the target
+func setTargetOptions(t *Target, options []string) error {
+    for _, option := range options {
+        kv := strings.SplitN(option, "=", 2)
+        if len(kv) != 2 {
+            return fmt.Errorf("expect option like key=value but got: %s", option)
+        }
+        switch kv[0] {
+        case "weight":
+            w, err := strconv.Atoi(kv[1])
+            if err != nil || w <= 0 {
+                return fmt.Errorf("weight must be a positive integer but got: %s", kv[1])
+            }
+            t.Weight = w
+        case "name":
+            t.SetName(kv[1])
+        default:
+            return fmt.Errorf("unknown option: %s", option)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestParseTargets(t *testing.T) {
+    body := filepath.Join(t.TempDir(), "body.json")
+    if err := os.WriteFile(body, []byte(`{"id":1}`), 0644); err != nil {
+        t.Fatal(err)
+    }
+    input := `# items
+GET http://localhost:8080/items?page=1
+X-Account-ID: 8675309
+
+POST http://localhost:8080/cart weight=3 name=add-to-cart
+Content-Type: application/json
+@` + body + `
+
+
+DELETE http://localhost:8080/cart/1
+GET http://localhost:8080/items/1
+X-Account-ID: 42
+HEAD http://localhost:8080/health weight=2
+`
+    targets, err := parseTargets(strings.NewReader(input), false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name   string
+        url    string
+        weight int
+        header string
+        value  string
+        body   string
+    }{
+        {"GET http://localhost:8080/items?page=1", "http://localhost:8080/items?page=1", 1, "X-Account-ID", "8675309", ""},
+        {"add-to-cart", "http://localhost:8080/cart", 3, "Content-Type", "application/json", `{"id":1}`},
+        {"DELETE http://localhost:8080/cart/1", "http://localhost:8080/cart/1", 1, "", "", ""},
+        // Targets on consecutive lines, without blank lines between them
+        {"GET http://localhost:8080/items/1", "http://localhost:8080/items/1", 1, "X-Account-ID", "42", ""},
+        {"HEAD http://localhost:8080/health", "http://localhost:8080/health", 2, "", "", ""},
+    }
+    if len(targets) != len(tests) {
+        t.Fatalf("%d targets parsed, want %d", len(targets), len(tests))
+    }
+    for i, tt := range tests {
+        target := targets[i]
+        if target.Name() != tt.name || target.Url != tt.url || target.Weight != tt.weight || string(target.Body) != tt.body {
+            t.Errorf("target %d = %s %s weight %d body %q, want %s %s weight %d body %q", i,
+                target.Name(), target.Url, target.Weight, target.Body, tt.name, tt.url, tt.weight, tt.body)
+        }
+        if tt.header != "" && target.header.Get(tt.header) != tt.value {
+            t.Errorf("target %d header %s = %q, want %q", i, tt.header, target.header.Get(tt.header), tt.value)
+        }
+    }
+}
+
+func TestParseTargetsErrors(t *testing.T) {
+    tests := []struct {
+        name  string
+        input string
+        err   string
+    }{
+        {"empty", "# nothing\n\n", errNoTargets.Error()},
+        {"no url", "GET\n", "line 1: expect 'METHOD URL'"},
+        {"bad method", "FETCH http://localhost/\n", "line 1:"},
+        {"bad method of a next target", "GET http://localhost/\nFETCH http://localhost/\n", "line 2:"},
+        {"bad header", "GET http://localhost/\nnot a header\n", "line 2: not valid http header"},
+        {"bad weight", "GET http://localhost/ weight=0\n", "weight must be a positive integer"},
+        {"unknown option", "GET http://localhost/ color=red\n", "unknown option"},
+        {"option without value", "GET http://localhost/ weight\n", "expect option like key=value"},
+        {"missing body file", "POST http://localhost/\n@/no/such/file\n", "line 2:"},
+    }
+    for _, tt := range tests {
+        _, err := parseTargets(strings.NewReader(tt.input), false)
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.err)
+        }
+    }
+}
+
+func TestTargetsWeightedRoundRobin(t *testing.T) {
+    a, b, c := NewTarget("http://a/"), NewTarget("http://b/"), NewTarget("http://c/")
+    a.Weight = 5
+    targets, err := NewTargets([]*Target{a, b, c}, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Smooth weighted round-robin interleaves the targets
+    want := []*Target{a, a, b, a, c, a, a}
+    for i, w := range want {
+        if got := targets.Next(); got != w {
+            t.Errorf("pick %d = %s, want %s", i, got.Url, w.Url)
+        }
+    }
+}
+
+func TestTargetsShares(t *testing.T) {
+    for _, order := range []string{orderRoundRobin, orderRandom} {
+        a, b := NewTarget("http://a/"), NewTarget("http://b/")
+        a.Weight, b.Weight = 3, 1
+        targets, err := NewTargets([]*Target{a, b}, order)
+        if err != nil {
+            t.Fatal(err)
+        }
+        hits := make(map[*Target]int)
+        for i := 0; i < 40000; i++ {
+            hits[targets.Next()]++
+        }
+        if share := float64(hits[a]) / 40000; share < 0.73 || share > 0.77 {
+            t.Errorf("%s: share of weight 3 of 4 = %.3f", order, share)
+        }
+    }
+}
+
+func TestNewTargetsErrors(t *testing.T) {
+    if _, err := NewTargets(nil, orderRoundRobin); err != errNoTargets {
+        t.Errorf("no targets: error = %v", err)
+    }
+    if _, err := NewTargets([]*Target{NewTarget("http://a/")}, "fifo"); err != errTargetsOrder {
+        t.Errorf("bad order: error = %v", err)
+    }
+    zero := NewTarget("http://a/")
+    zero.Weight = 0
+    if _, err := NewTargets([]*Target{zero}, orderRoundRobin); err == nil {
+        t.Error("zero weight: no error")
+    }
+}
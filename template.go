@@ -0,0 +1,317 @@
+package main
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/csv"
+    "fmt"
+    mrand "math/rand"
+    "net/http"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "text/template"
+    "time"
+    "unicode/utf8"
+)
+
+// Modes of feeders to pick rows from data files
+const (
+    feedSequential = "sequential" // Each row is used once, fails when all rows are used
+    feedCircular = "circular"     // Rows are used in order, starts over when all rows are used
+    feedRandom = "random"         // Rows are picked at random
+)
+
+// Sequence number of the rendered requests
+var requestSeq uint64
+
+// Data of a rendered request, eg. {{.Seq}}
+type templateData struct {
+    Seq  uint64    // Sequence number of the request, starts from 1
+    Time time.Time // When the request is rendered
+}
+
+// Templates of a target. The url, header values and body may contain actions of text/template,
+// they are parsed once and rendered freshly for every request.
+//
+//     {{.Seq}}                  sequence number of the request
+//     {{uuid}}                  a random UUID
+//     {{randInt 1 1000}}        a random integer in [1, 1000]
+//     {{randString 16}}         a random alphanumeric string of 16 characters
+//     {{csv "users.csv" "id"}}  the id column of a row in users.csv
+//
+// All csv actions of a request on the same file read the same row.
+type targetTemplate struct {
+    root    *template.Template
+    url     bool
+    body    bool
+    headers map[string][]bool // Whether each value of header is templated
+    feeders *Feeders
+    pool    sync.Pool         // Clones of root to render with, a *templateRenderer each
+}
+
+// A clone of the templates of a target, csv actions read the rows picked for the request being rendered.
+// It's used by one request at a time.
+type templateRenderer struct {
+    root *template.Template
+    rows map[string][]string
+}
+
+// Find the data files used by csv actions
+var csvActionFinder = regexp.MustCompile(`\bcsv\s+"([^"]+)"`)
+
+// Compile the url, header values and body as templates, files used by csv actions are loaded by feeders.
+// Target without any action is requested as it is.
+func (t *Target) Compile(feeders *Feeders) error {
+    tt := &targetTemplate{
+        root: template.New(t.Name()).Funcs(templateFuncs(nil)),
+        headers: make(map[string][]bool),
+        feeders: feeders,
+    }
+    templated := false
+    parse := func(name, text string) (bool, error) {
+        if !strings.Contains(text, "{{") {
+            return false, nil
+        }
+        for _, m := range csvActionFinder.FindAllStringSubmatch(text, -1) {
+            if _, err := feeders.Load(m[1]); err != nil {
+                return false, err
+            }
+        }
+        if _, err := tt.root.New(name).Parse(text); err != nil {
+            return false, err
+        }
+        templated = true
+        return true, nil
+    }
+
+    var err error
+    if tt.url, err = parse("url", t.Url); err != nil {
+        return err
+    }
+    // Binary bodies are never templates
+    if utf8.Valid(t.Body) {
+        if tt.body, err = parse("body", string(t.Body)); err != nil {
+            return err
+        }
+    }
+    for k, vs := range t.header {
+        tt.headers[k] = make([]bool, len(vs))
+        for i, v := range vs {
+            if tt.headers[k][i], err = parse(headerTemplateName(k, i), v); err != nil {
+                return err
+            }
+        }
+    }
+    if templated {
+        t.tmpl = tt
+    }
+    return nil
+}
+
+// Take a renderer from the pool, a new clone is made if it's empty.
+func (tt *targetTemplate) renderer() (*templateRenderer, error) {
+    if r, ok := tt.pool.Get().(*templateRenderer); ok {
+        return r, nil
+    }
+    root, err := tt.root.Clone()
+    if err != nil {
+        return nil, err
+    }
+    r := &templateRenderer{root: root, rows: make(map[string][]string)}
+    root.Funcs(templateFuncs(func(file, column string) (string, error) {
+        return tt.feeders.value(r.rows, file, column)
+    }))
+    return r, nil
+}
+
+// Render the url, header and body of a request
+func (tt *targetTemplate) render(t *Target) (url string, header http.Header, body []byte, err error) {
+    r, err := tt.renderer()
+    if err != nil {
+        return
+    }
+    defer func() {
+        for file := range r.rows {
+            delete(r.rows, file)
+        }
+        tt.pool.Put(r)
+    }()
+    data := &templateData{Seq: atomic.AddUint64(&requestSeq, 1), Time: time.Now()}
+    execute := func(name string) (string, error) {
+        var buf bytes.Buffer
+        if err := r.root.ExecuteTemplate(&buf, name, data); err != nil {
+            return "", err
+        }
+        return buf.String(), nil
+    }
+
+    url, header, body = t.Url, t.header, t.Body
+    if tt.url {
+        if url, err = execute("url"); err != nil {
+            return
+        }
+    }
+    if tt.body {
+        var s string
+        if s, err = execute("body"); err != nil {
+            return
+        }
+        body = []byte(s)
+    }
+    header = make(http.Header, len(t.header))
+    for k, vs := range t.header {
+        header[k] = make([]string, len(vs))
+        for i, v := range vs {
+            if !tt.headers[k][i] {
+                header[k][i] = v
+            } else if header[k][i], err = execute(headerTemplateName(k, i)); err != nil {
+                return
+            }
+        }
+    }
+    return
+}
+
+func headerTemplateName(key string, i int) string {
+    return fmt.Sprintf("header:%s:%d", key, i)
+}
+
+// Functions can be used in templates, csv reads the column of the row picked for the request.
+func templateFuncs(csvFunc func(file, column string) (string, error)) template.FuncMap {
+    if csvFunc == nil {
+        csvFunc = func(file, column string) (string, error) {
+            return "", nil
+        }
+    }
+    return template.FuncMap{
+        "uuid": newUUID,
+        "randInt": func(min, max int) int {
+            if max <= min {
+                return min
+            }
+            return min + mrand.Intn(max - min + 1)
+        },
+        "randString": randString,
+        "csv": csvFunc,
+    }
+}
+
+// Create a random(version 4) UUID
+func newUUID() (string, error) {
+    var u [16]byte
+    if _, err := rand.Read(u[:]); err != nil {
+        return "", err
+    }
+    u[6] = (u[6] & 0x0f) | 0x40
+    u[8] = (u[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Create a random alphanumeric string of n characters
+func randString(n int) string {
+    b := make([]byte, n)
+    for i := range b {
+        b[i] = letters[mrand.Intn(len(letters))]
+    }
+    return string(b)
+}
+
+// Feeders hold the data files used by templates, rows are picked by the mode.
+type Feeders struct {
+    mode  string
+    mu    sync.Mutex
+    files map[string]*Feeder
+}
+
+// A Feeder is a CSV file whose first row is the names of columns
+type Feeder struct {
+    file    string
+    mode    string
+    columns map[string]int
+    rows    [][]string
+    next    uint64
+}
+
+// Create feeders picking rows by mode, which is sequential, circular or random.
+func NewFeeders(mode string) (*Feeders, error) {
+    switch mode {
+    case "":
+        mode = feedCircular
+    case feedSequential, feedCircular, feedRandom:
+    default:
+        return nil, errFeederMode
+    }
+    return &Feeders{mode: mode, files: make(map[string]*Feeder)}, nil
+}
+
+// Load the feeder of the file, it's loaded only once.
+func (fs *Feeders) Load(file string) (*Feeder, error) {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if f, ok := fs.files[file]; ok {
+        return f, nil
+    }
+    f, err := loadFeeder(file, fs.mode)
+    if err != nil {
+        return nil, err
+    }
+    fs.files[file] = f
+    return f, nil
+}
+
+// The value of column in the row picked for a request, rows holds the rows already picked for it.
+func (fs *Feeders) value(rows map[string][]string, file, column string) (string, error) {
+    f, err := fs.Load(file)
+    if err != nil {
+        return "", err
+    }
+    row, ok := rows[file]
+    if !ok {
+        if row, err = f.Next(); err != nil {
+            return "", err
+        }
+        rows[file] = row
+    }
+    idx, ok := f.columns[column]
+    if !ok {
+        return "", fmt.Errorf("no column %s in %s", column, file)
+    }
+    return row[idx], nil
+}
+
+func loadFeeder(file, mode string) (*Feeder, error) {
+    in, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer in.Close()
+    records, err := csv.NewReader(in).ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("read %s error: %s", file, err)
+    }
+    if len(records) < 2 {
+        return nil, fmt.Errorf("%s must have a header row and at least one data row", file)
+    }
+    f := &Feeder{file: file, mode: mode, columns: make(map[string]int), rows: records[1:]}
+    for i, name := range records[0] {
+        f.columns[strings.TrimSpace(name)] = i
+    }
+    return f, nil
+}
+
+// Pick the next row, it's safe to be called by multi goroutines.
+func (f *Feeder) Next() ([]string, error) {
+    if f.mode == feedRandom {
+        return f.rows[mrand.Intn(len(f.rows))], nil
+    }
+    n := atomic.AddUint64(&f.next, 1) - 1
+    if n >= uint64(len(f.rows)) && f.mode == feedSequential {
+        return nil, fmt.Errorf("all %d rows of %s are used", len(f.rows), f.file)
+    }
+    return f.rows[n % uint64(len(f.rows))], nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func writeUsers(t *testing.T) string {
+    file := filepath.Join(t.TempDir(), "users.csv")
+    if err := os.WriteFile(file, []byte("id,name\n1,ann\n2,bob\n3,cat\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return file
+}
+
+func compiledTarget(t *testing.T, url string, body string, mode string) *Target {
+    target := NewTarget(url)
+    target.Body = []byte(body)
+    feeders, err := NewFeeders(mode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := target.Compile(feeders); err != nil {
+        t.Fatal(err)
+    }
+    return target
+}
+
+func TestTemplateRender(t *testing.T) {
+    users := writeUsers(t)
+    tests := []struct {
+        name string
+        url  string
+        want string
+    }{
+        {"plain", "http://localhost/items", `^http://localhost/items$`},
+        {"seq", "http://localhost/items/{{.Seq}}", `^http://localhost/items/\d+$`},
+        {"uuid", "http://localhost/{{uuid}}", `^http://localhost/[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+        {"randInt", "http://localhost/{{randInt 5 5}}", `^http://localhost/5$`},
+        {"randString", "http://localhost/{{randString 16}}", `^http://localhost/[a-zA-Z0-9]{16}$`},
+        {"csv", `http://localhost/{{csv "` + users + `" "id"}}`, `^http://localhost/1$`},
+    }
+    for _, tt := range tests {
+        target := compiledTarget(t, tt.url, "", feedCircular)
+        req, err := target.Request()
+        if err != nil {
+            t.Errorf("%s: %s", tt.name, err)
+            continue
+        }
+        if !regexp.MustCompile(tt.want).MatchString(req.URL.String()) {
+            t.Errorf("%s: url = %s, want matching %s", tt.name, req.URL, tt.want)
+        }
+    }
+}
+
+func TestTemplateCompileErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        url  string
+    }{
+        {"syntax", "http://localhost/{{uuid"},
+        {"unknown function", "http://localhost/{{nope}}"},
+        {"missing csv file", `http://localhost/{{csv "/no/such.csv" "id"}}`},
+    }
+    for _, tt := range tests {
+        feeders, _ := NewFeeders(feedCircular)
+        if err := NewTarget(tt.url).Compile(feeders); err == nil {
+            t.Errorf("%s: no error", tt.name)
+        }
+    }
+}
+
+// Without -templates the targets are never compiled, braces are sent as they are
+func TestTemplateLiteralBraces(t *testing.T) {
+    target := NewTarget("http://localhost/items")
+    target.Body = []byte(`{"text":"{{not a template}}"}`)
+    req, err := target.Request()
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := ioutil.ReadAll(req.Body)
+    if string(body) != string(target.Body) {
+        t.Errorf("body = %s, want %s", body, target.Body)
+    }
+}
+
+// All csv actions of a request read the same row, and each request reads a row of its own
+func TestTemplateCSVRows(t *testing.T) {
+    users := writeUsers(t)
+    target := compiledTarget(t, `http://localhost/{{csv "` + users + `" "id"}}`,
+        `{{csv "` + users + `" "id"}}:{{csv "` + users + `" "name"}}`, feedCircular)
+    names := map[string]string{"1": "ann", "2": "bob", "3": "cat"}
+
+    var mu sync.Mutex
+    seen := make(map[string]int)
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 300; i++ {
+                req, err := target.Request()
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                body, _ := ioutil.ReadAll(req.Body)
+                parts := strings.SplitN(string(body), ":", 2)
+                id := strings.TrimPrefix(req.URL.Path, "/")
+                if len(parts) != 2 || parts[0] != id || names[id] != parts[1] {
+                    t.Errorf("url %s and body %s are not the same row", req.URL, body)
+                    return
+                }
+                mu.Lock()
+                seen[id]++
+                mu.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+    for id := range names {
+        if seen[id] != 800 {
+            t.Errorf("row %s used %d times, want 800", id, seen[id])
+        }
+    }
+}
+
+func TestTemplateSequentialFeeder(t *testing.T) {
+    users := writeUsers(t)
+    target := compiledTarget(t, `http://localhost/{{csv "` + users + `" "id"}}`, "", feedSequential)
+    for i := 1; i <= 3; i++ {
+        req, err := target.Request()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if req.URL.Path != "/" + strconv.Itoa(i) {
+            t.Errorf("request %d url = %s", i, req.URL)
+        }
+    }
+    if _, err := target.Request(); err == nil || !strings.Contains(err.Error(), "all 3 rows") {
+        t.Errorf("request after all rows are used: error = %v", err)
+    }
+}
+
+func TestTemplateHeaders(t *testing.T) {
+    target := NewTarget("http://localhost/")
+    target.AddHeader("X-Request-ID", "req-{{.Seq}}")
+    target.AddHeader("X-Plain", "{literal}")
+    feeders, _ := NewFeeders(feedCircular)
+    if err := target.Compile(feeders); err != nil {
+        t.Fatal(err)
+    }
+    req, err := target.Request()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !regexp.MustCompile(`^req-\d+$`).MatchString(req.Header.Get("X-Request-ID")) {
+        t.Errorf("templated header = %s", req.Header.Get("X-Request-ID"))
+    }
+    if req.Header.Get("X-Plain") != "{literal}" {
+        t.Errorf("plain header = %s", req.Header.Get("X-Plain"))
+    }
+}
+
+type recordingEngine struct{}
+
+func (recordingEngine) Hit(req *http.Request, damage *Damage) {
+    damage.StatusCode = 200
+}
+
+// Dumps tell the rendered request, not the template
+func TestHitRecordsRenderedRequest(t *testing.T) {
+    target := compiledTarget(t, "http://localhost/items/{{randInt 7 7}}", "", feedCircular)
+    missile := &Missile{engine: recordingEngine{}}
+    damage := missile.hit(target, time.Now())
+    if damage.Target != "GET http://localhost/items/{{randInt 7 7}}" {
+        t.Errorf("target = %s", damage.Target)
+    }
+    if damage.Method != "GET" || damage.URL != "http://localhost/items/7" {
+        t.Errorf("request = %s %s, want GET http://localhost/items/7", damage.Method, damage.URL)
+    }
+}
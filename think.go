@@ -0,0 +1,79 @@
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "strings"
+    "time"
+)
+
+// Distributions of think time
+const (
+    thinkFixed = "fixed"
+    thinkUniform = "uniform"
+    thinkExponential = "exp"
+)
+
+// ThinkTime is how long a virtual user waits after a response before sending the next request, eg.
+//
+//     500ms               always 500ms
+//     uniform:100ms-1s    uniformly distributed between 100ms and 1s
+//     exp:500ms           exponentially distributed with a mean of 500ms
+type ThinkTime struct {
+    kind string
+    min  time.Duration // The fixed time, or the lower bound of uniform
+    max  time.Duration // The upper bound of uniform
+    mean time.Duration // The mean of exponential
+}
+
+// Parse a think time, see ThinkTime. Returns nil if spec is empty, which means no think time.
+func ParseThinkTime(spec string) (*ThinkTime, error) {
+    if spec == "" {
+        return nil, nil
+    }
+    parts := strings.SplitN(spec, ":", 2)
+    if len(parts) == 1 {
+        d, err := time.ParseDuration(spec)
+        if err != nil || d < 0 {
+            return nil, fmt.Errorf("not valid think time: %s", spec)
+        }
+        return &ThinkTime{kind: thinkFixed, min: d}, nil
+    }
+    switch parts[0] {
+    case thinkUniform:
+        bounds := strings.SplitN(parts[1], "-", 2)
+        if len(bounds) != 2 {
+            return nil, fmt.Errorf("uniform think time must be like uniform:100ms-1s: %s", spec)
+        }
+        min, err := time.ParseDuration(bounds[0])
+        if err != nil || min < 0 {
+            return nil, fmt.Errorf("not valid think time: %s", spec)
+        }
+        max, err := time.ParseDuration(bounds[1])
+        if err != nil || max < min {
+            return nil, fmt.Errorf("not valid think time: %s", spec)
+        }
+        return &ThinkTime{kind: thinkUniform, min: min, max: max}, nil
+    case thinkExponential:
+        mean, err := time.ParseDuration(parts[1])
+        if err != nil || mean < 0 {
+            return nil, fmt.Errorf("not valid think time: %s", spec)
+        }
+        return &ThinkTime{kind: thinkExponential, mean: mean}, nil
+    }
+    return nil, fmt.Errorf("think time must be a duration, uniform:MIN-MAX or exp:MEAN: %s", spec)
+}
+
+// Pick the time of the next think, it's safe to be called by multi goroutines.
+func (t *ThinkTime) next() time.Duration {
+    if t == nil {
+        return 0
+    }
+    switch t.kind {
+    case thinkUniform:
+        return t.min + time.Duration(rand.Int63n(int64(t.max - t.min) + 1))
+    case thinkExponential:
+        return time.Duration(rand.ExpFloat64() * float64(t.mean))
+    }
+    return t.min
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "math"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+    tests := []struct {
+        spec string
+        want *ThinkTime
+        err  string
+    }{
+        {"", nil, ""},
+        {"500ms", &ThinkTime{kind: thinkFixed, min: 500 * time.Millisecond}, ""},
+        {"0s", &ThinkTime{kind: thinkFixed}, ""},
+        {"uniform:100ms-1s", &ThinkTime{kind: thinkUniform, min: 100 * time.Millisecond, max: time.Second}, ""},
+        {"uniform:1s-1s", &ThinkTime{kind: thinkUniform, min: time.Second, max: time.Second}, ""},
+        {"exp:500ms", &ThinkTime{kind: thinkExponential, mean: 500 * time.Millisecond}, ""},
+        {"soon", nil, "not valid think time"},
+        {"-1s", nil, "not valid think time"},
+        {"uniform:100ms", nil, "like uniform:100ms-1s"},
+        {"uniform:1s-100ms", nil, "not valid think time"},
+        {"uniform:x-1s", nil, "not valid think time"},
+        {"exp:-1s", nil, "not valid think time"},
+        {"normal:1s", nil, "must be a duration, uniform:MIN-MAX or exp:MEAN"},
+    }
+    for _, tt := range tests {
+        got, err := ParseThinkTime(tt.spec)
+        if tt.err != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("ParseThinkTime(%q): error = %v, want containing %q", tt.spec, err, tt.err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("ParseThinkTime(%q): %s", tt.spec, err)
+            continue
+        }
+        if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+            t.Errorf("ParseThinkTime(%q) = %+v, want %+v", tt.spec, got, tt.want)
+        }
+    }
+}
+
+func TestThinkTimeNext(t *testing.T) {
+    tests := []struct {
+        spec     string
+        min, max time.Duration
+        mean     time.Duration
+    }{
+        {"", 0, 0, 0},
+        {"250ms", 250 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond},
+        {"uniform:100ms-300ms", 100 * time.Millisecond, 300 * time.Millisecond, 200 * time.Millisecond},
+        {"exp:100ms", 0, time.Duration(math.MaxInt64), 100 * time.Millisecond},
+    }
+    const n = 20000
+    for _, tt := range tests {
+        think, err := ParseThinkTime(tt.spec)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var sum time.Duration
+        for i := 0; i < n; i++ {
+            d := think.next()
+            if d < tt.min || d > tt.max {
+                t.Errorf("%q: think %v out of [%v, %v]", tt.spec, d, tt.min, tt.max)
+                break
+            }
+            sum += d
+        }
+        mean := sum / n
+        if diff := math.Abs(float64(mean - tt.mean)); diff > float64(tt.mean) * 0.03 {
+            t.Errorf("%q: mean think = %v, want %v", tt.spec, mean, tt.mean)
+        }
+    }
+}
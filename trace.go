@@ -0,0 +1,165 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "net/http/httptrace"
+    "sync"
+    "time"
+)
+
+// requestTrace records the phases of a request by httptrace.
+// Hooks of a dial may be called by other goroutines, even after the request is done, so it's guarded by a mutex.
+type requestTrace struct {
+    mu           sync.Mutex
+    dnsStart     time.Time
+    connectStart time.Time
+    tlsStart     time.Time
+    wroteRequest time.Time
+    firstByte    time.Time
+    dnsLookup    time.Duration
+    connect      time.Duration
+    tlsHandshake time.Duration
+    connReused   bool
+    http3        bool // Over HTTP/3, which has no hooks of connections but the QUIC dial
+    quicDialed   bool
+    quicDone     chan struct{} // Closed when the handshake of the QUIC connection dialed is recorded, or it's failed
+    used0RTT     bool
+}
+
+type requestTraceKey struct{}
+
+// Attach the trace to the context, so transports without httptrace hooks can record the phases.
+func withRequestTrace(ctx context.Context, t *requestTrace) context.Context {
+    return context.WithValue(ctx, requestTraceKey{}, t)
+}
+
+// The trace attached to the context, nil if there isn't one
+func requestTraceOf(ctx context.Context) *requestTrace {
+    t, _ := ctx.Value(requestTraceKey{}).(*requestTrace)
+    return t
+}
+
+// Record a new QUIC connection dialed for the request
+func (t *requestTrace) quicDial() {
+    if t == nil {
+        return
+    }
+    t.mu.Lock()
+    t.quicDialed = true
+    if t.quicDone == nil {
+        t.quicDone = make(chan struct{})
+    }
+    t.mu.Unlock()
+}
+
+// Record the QUIC handshake of the connection dialed for the request, and whether 0-RTT is used.
+// It's called with zeros if the connection is failed before the handshake completes.
+func (t *requestTrace) quicHandshakeDone(handshake time.Duration, used0RTT bool) {
+    if t == nil {
+        return
+    }
+    t.mu.Lock()
+    // The request may dial again, eg. 0-RTT is rejected, the first one done counts
+    select {
+    case <-t.quicDone:
+    default:
+        t.tlsHandshake = handshake
+        t.used0RTT = used0RTT
+        close(t.quicDone)
+    }
+    t.mu.Unlock()
+}
+
+// Wait for the handshake of the QUIC connection dialed for the request to be recorded,
+// a response sent in 0-RTT may arrive before that.
+func (t *requestTrace) waitQUICHandshake() {
+    t.mu.Lock()
+    done := t.quicDone
+    t.mu.Unlock()
+    if done != nil {
+        <-done
+    }
+}
+
+// The hooks recording the phases
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+    return &httptrace.ClientTrace{
+        DNSStart: func(httptrace.DNSStartInfo) {
+            t.mark(&t.dnsStart)
+        },
+        DNSDone: func(httptrace.DNSDoneInfo) {
+            t.measure(&t.dnsLookup, &t.dnsStart)
+        },
+        ConnectStart: func(network, addr string) {
+            t.mark(&t.connectStart)
+        },
+        ConnectDone: func(network, addr string, err error) {
+            if err == nil {
+                t.measure(&t.connect, &t.connectStart)
+            }
+        },
+        TLSHandshakeStart: func() {
+            t.mark(&t.tlsStart)
+        },
+        TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+            if err == nil {
+                t.measure(&t.tlsHandshake, &t.tlsStart)
+            }
+        },
+        GotConn: func(info httptrace.GotConnInfo) {
+            t.mu.Lock()
+            t.connReused = info.Reused
+            t.mu.Unlock()
+        },
+        WroteRequest: func(httptrace.WroteRequestInfo) {
+            t.mark(&t.wroteRequest)
+        },
+        GotFirstResponseByte: func() {
+            t.mark(&t.firstByte)
+        },
+    }
+}
+
+// Set the start of a phase, only the first start counts if the hook is called more than once, eg. dialing both IPv4 and IPv6.
+func (t *requestTrace) mark(start *time.Time) {
+    t.mu.Lock()
+    if start.IsZero() {
+        *start = time.Now()
+    }
+    t.mu.Unlock()
+}
+
+// Set the duration of a phase since its start, only the first one done counts.
+func (t *requestTrace) measure(d *time.Duration, start *time.Time) {
+    t.mu.Lock()
+    if *d == 0 && !start.IsZero() {
+        *d = time.Since(*start)
+    }
+    t.mu.Unlock()
+}
+
+// Copy the phases to the damage, done is when the body has been read.
+func (t *requestTrace) apply(damage *Damage, done time.Time) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    damage.ConnReused = t.connReused
+    if t.http3 {
+        damage.ConnReused = !t.quicDialed
+        damage.Used0RTT = t.used0RTT
+    }
+    // The dial started for the request may be used by another one if a kept-alive connection comes first
+    if !t.connReused {
+        damage.DNSLookup = t.dnsLookup
+        damage.Connect = t.connect
+        damage.TLSHandshake = t.tlsHandshake
+    }
+    if !t.firstByte.IsZero() {
+        if !t.wroteRequest.IsZero() {
+            damage.FirstByte = t.firstByte.Sub(t.wroteRequest)
+        }
+        if !done.IsZero() {
+            damage.Download = done.Sub(t.firstByte)
+        }
+    }
+}
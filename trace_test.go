@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "net/http/httptrace"
+    "testing"
+    "time"
+)
+
+func TestRequestTraceApply(t *testing.T) {
+    start := time.Now()
+    tests := []struct {
+        name  string
+        trace *requestTrace
+        done  time.Time
+        want  Damage
+    }{
+        {"new connection", &requestTrace{dnsLookup: 1, connect: 2, tlsHandshake: 3,
+            wroteRequest: start, firstByte: start.Add(5)}, start.Add(12),
+            Damage{DNSLookup: 1, Connect: 2, TLSHandshake: 3, FirstByte: 5, Download: 7}},
+        // Phases of a dial taken over by another request don't count
+        {"reused", &requestTrace{connReused: true, dnsLookup: 1, connect: 2,
+            wroteRequest: start, firstByte: start.Add(5)}, start.Add(6),
+            Damage{ConnReused: true, FirstByte: 5, Download: 1}},
+        {"no response", &requestTrace{connect: 2}, start, Damage{Connect: 2}},
+        {"not read", &requestTrace{wroteRequest: start, firstByte: start.Add(5)}, time.Time{}, Damage{FirstByte: 5}},
+        {"http3 dialed", &requestTrace{http3: true, quicDialed: true, tlsHandshake: 4, used0RTT: true}, start,
+            Damage{TLSHandshake: 4, Used0RTT: true}},
+        {"http3 reused", &requestTrace{http3: true}, start, Damage{ConnReused: true}},
+    }
+    for _, tt := range tests {
+        damage := &Damage{}
+        tt.trace.apply(damage, tt.done)
+        if *damage != tt.want {
+            t.Errorf("%s: damage %+v, want %+v", tt.name, *damage, tt.want)
+        }
+    }
+}
+
+// Only the first start and the first end of a phase count
+func TestRequestTraceMarkMeasure(t *testing.T) {
+    trace := &requestTrace{}
+    trace.measure(&trace.connect, &trace.connectStart)
+    if trace.connect != 0 {
+        t.Errorf("measured %v without a start", trace.connect)
+    }
+    trace.mark(&trace.connectStart)
+    first := trace.connectStart
+    time.Sleep(time.Millisecond)
+    trace.mark(&trace.connectStart)
+    if !trace.connectStart.Equal(first) {
+        t.Error("the start is moved by a second mark")
+    }
+    trace.measure(&trace.connect, &trace.connectStart)
+    measured := trace.connect
+    time.Sleep(time.Millisecond)
+    trace.measure(&trace.connect, &trace.connectStart)
+    if measured <= 0 || trace.connect != measured {
+        t.Errorf("connect %v, then %v", measured, trace.connect)
+    }
+}
+
+func TestRequestTraceQUIC(t *testing.T) {
+    var missing *requestTrace
+    missing.quicDial()
+    missing.quicHandshakeDone(time.Second, true)
+
+    trace := &requestTrace{http3: true}
+    // Nothing to wait for without a dial
+    trace.waitQUICHandshake()
+
+    trace.quicDial()
+    waited := make(chan struct{})
+    go func() {
+        trace.waitQUICHandshake()
+        close(waited)
+    }()
+    select {
+    case <-waited:
+        t.Fatal("waited before the handshake is done")
+    case <-time.After(20 * time.Millisecond):
+    }
+    trace.quicHandshakeDone(5 * time.Millisecond, true)
+    // A dial again after 0-RTT is rejected doesn't count
+    trace.quicDial()
+    trace.quicHandshakeDone(time.Second, false)
+    <-waited
+
+    damage := &Damage{}
+    trace.apply(damage, time.Now())
+    if damage.ConnReused || damage.TLSHandshake != 5 * time.Millisecond || !damage.Used0RTT {
+        t.Errorf("damage %+v", *damage)
+    }
+}
+
+func TestRequestTraceContext(t *testing.T) {
+    if requestTraceOf(context.Background()) != nil {
+        t.Error("a trace without attaching one")
+    }
+    trace := &requestTrace{}
+    if requestTraceOf(withRequestTrace(context.Background(), trace)) != trace {
+        t.Error("not the trace attached")
+    }
+}
+
+// The hooks record the phases of real requests, a new connection then a kept-alive one
+func TestRequestTraceHooks(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer server.Close()
+    client := server.Client()
+    for i, reused := range []bool{false, true} {
+        trace := &requestTrace{}
+        req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+        req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+        resp, err := client.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ioutil.ReadAll(resp.Body)
+        resp.Body.Close()
+        damage := &Damage{}
+        trace.apply(damage, time.Now())
+        if damage.ConnReused != reused || (damage.Connect > 0) == reused || damage.FirstByte <= 0 || damage.Download < 0 {
+            t.Errorf("request %d: damage %+v", i, *damage)
+        }
+    }
+}
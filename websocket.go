@@ -0,0 +1,455 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/url"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// Opcodes of WebSocket frames, see RFC 6455
+const (
+    wsContinuation = 0x0
+    wsText = 0x1
+    wsBinary = 0x2
+    wsClose = 0x8
+    wsPing = 0x9
+    wsPong = 0xa
+)
+
+// Appended to the key of the handshake to make the accept of the server
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Most connections opening at the same time before the attack
+const maxWSOpening = 64
+
+// Headers of the request which are not sent in the handshake
+var wsSkippedHeaders = map[string]bool{
+    "Content-Type": true,
+    "Content-Length": true,
+}
+
+// The server closed the connection by a close frame
+type wsCloseError struct {
+    code   int
+    reason string
+}
+
+func (e *wsCloseError) Error() string {
+    if e.reason == "" {
+        return fmt.Sprintf("websocket closed by server: %d", e.code)
+    }
+    return fmt.Sprintf("websocket closed by server: %d %s", e.code, e.reason)
+}
+
+// wsEngine sends messages over WebSocket connections, the target URL is like ws://host/path, wss:// for TLS.
+// Each hit sends the request body as a message, then waits for the reply: the echo of the message,
+// or the message with the same correlation id if it's set. Other messages from the server are skipped.
+// There are -g connections, each one has a message in flight at most. They are opened before the attack by the
+// handshake of the target, and opened again by the request of the message if they drop. All the targets must have
+// the same url, as any message goes over any connection.
+type wsEngine struct {
+    ctrl      *CtrlCenter
+    dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+    correlate []interface{} // JSON path of the correlation id, nil to wait for the echo
+    slots     chan *wsConn  // Connections free to send, nil ones are dropped
+}
+
+// Create the WebSocket engine, correlate is the JSON path of the correlation id in the messages, eg. $.id
+// All the connections are opened now, so a failed handshake is reported before the attack.
+func newWSEngine(ct *CtrlCenter, dial func(ctx context.Context, network, addr string) (net.Conn, error),
+    correlate string, targets *Targets) (Engine, error) {
+
+    e := &wsEngine{
+        ctrl: ct,
+        dial: dial,
+        slots: make(chan *wsConn, ct.Warheads),
+    }
+    if correlate != "" {
+        path, err := parseJSONPath(correlate)
+        if err != nil {
+            return nil, err
+        }
+        e.correlate = path
+    }
+    list := targets.List()
+    for _, target := range list[1:] {
+        if target.Url != list[0].Url {
+            return nil, errWSTargets
+        }
+    }
+    if err := e.openAll(list[0]); err != nil {
+        return nil, err
+    }
+    return e, nil
+}
+
+// Open the connections of all the slots by the handshake of the target, maxWSOpening at a time.
+// None is left open if any of them fails.
+func (e *wsEngine) openAll(target *Target) error {
+    conns := make([]*wsConn, e.ctrl.Warheads)
+    errs := make([]error, len(conns))
+    opening := make(chan struct{}, maxWSOpening)
+    var wg sync.WaitGroup
+    for i := range conns {
+        wg.Add(1)
+        opening <- struct{}{}
+        go func(i int) {
+            defer wg.Done()
+            defer func() {
+                <-opening
+            }()
+            req, err := target.Request()
+            if err != nil {
+                errs[i] = err
+                return
+            }
+            opened := &Damage{}
+            if conns[i], errs[i] = e.open(req, opened, time.Now().Add(e.ctrl.Timeout)); errs[i] == nil {
+                conns[i].opened = opened
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    failed := 0
+    var firstErr error
+    for _, err := range errs {
+        if err != nil {
+            if firstErr == nil {
+                firstErr = err
+            }
+            failed++
+        }
+    }
+    if firstErr != nil {
+        for _, conn := range conns {
+            if conn != nil {
+                conn.Close()
+            }
+        }
+        return fmt.Errorf("%d of %d websocket connections to %s failed to open: %s", failed, len(conns), target.Url,
+            firstErr)
+    }
+    for _, conn := range conns {
+        e.slots <- conn
+    }
+    return nil
+}
+
+// Send the message and wait for the reply
+func (e *wsEngine) Hit(req *http.Request, damage *Damage) {
+    conn := <-e.slots
+    defer func() {
+        e.slots <- conn
+    }()
+    damage.Proto = "WebSocket"
+
+    var msg []byte
+    if req.Body != nil {
+        var err error
+        msg, err = ioutil.ReadAll(req.Body)
+        req.Body.Close()
+        if err != nil {
+            damage.setError(err)
+            return
+        }
+    }
+    var id interface{}
+    if e.correlate != nil {
+        var ok bool
+        if id, ok = lookupJSON(msg, e.correlate); !ok {
+            damage.setError(fmt.Errorf("no correlation id in the message: %s", msg))
+            return
+        }
+    }
+
+    deadline := time.Now().Add(e.ctrl.Timeout)
+    damage.ConnReused = conn != nil && conn.opened == nil
+    if conn != nil && conn.opened != nil {
+        // The first message on a connection opened before the attack tells how it's opened
+        damage.Connect = conn.opened.Connect
+        damage.TLSHandshake = conn.opened.TLSHandshake
+        damage.Handshake = conn.opened.Handshake
+        conn.opened = nil
+    }
+    if conn == nil {
+        var err error
+        damage.StartTime = time.Now()
+        if conn, err = e.open(req, damage, deadline); err != nil {
+            damage.EndTime = time.Now()
+            damage.Latency = damage.EndTime.Sub(damage.StartTime)
+            damage.setError(err)
+            if damage.StatusCode != 0 {
+                damage.ErrorKind = errKindStatus
+            }
+            return
+        }
+    }
+    conn.SetDeadline(deadline)
+
+    opcode := byte(wsText)
+    if !utf8.Valid(msg) {
+        opcode = wsBinary
+    }
+    damage.StartTime = time.Now()
+    reply, err := conn.send(opcode, msg, func(reply []byte) bool {
+        if e.correlate == nil {
+            return bytes.Equal(reply, msg)
+        }
+        v, ok := lookupJSON(reply, e.correlate)
+        return ok && jsonEqual(v, id)
+    })
+    damage.EndTime = time.Now()
+    damage.Latency = damage.EndTime.Sub(damage.StartTime)
+    damage.SentBytes = uint64(len(msg))
+    if err != nil {
+        // The connection is dropped or broken by the timeout, open another one next time
+        conn.Close()
+        conn = nil
+        damage.setError(err)
+        if _, ok := err.(*wsCloseError); ok {
+            damage.ErrorKind = errKindWSClosed
+        }
+        return
+    }
+    damage.ReceivedBytes = uint64(len(reply))
+    if kind, msg := e.ctrl.Expect.checkBody(reply); kind != "" {
+        damage.Error = msg
+        damage.ErrorKind = kind
+    }
+}
+
+// Open a connection by the handshake of the request, the phases are recorded to the damage.
+func (e *wsEngine) open(req *http.Request, damage *Damage, deadline time.Time) (*wsConn, error) {
+    u := req.URL
+    secure := false
+    switch u.Scheme {
+    case "ws", "http":
+    case "wss", "https":
+        secure = true
+    default:
+        return nil, errWSURL
+    }
+    addr := u.Host
+    if u.Port() == "" {
+        port := "80"
+        if secure {
+            port = "443"
+        }
+        addr = net.JoinHostPort(u.Hostname(), port)
+    }
+
+    ctx, cancel := context.WithDeadline(req.Context(), deadline)
+    defer cancel()
+    start := time.Now()
+    conn, err := e.dial(ctx, "tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    damage.Connect = time.Since(start)
+    if secure {
+        config := e.ctrl.TLSConfig.Clone()
+        if config.ServerName == "" {
+            config.ServerName = u.Hostname()
+        }
+        tlsConn := tls.Client(conn, config)
+        start = time.Now()
+        if err := tlsConn.HandshakeContext(ctx); err != nil {
+            conn.Close()
+            return nil, err
+        }
+        damage.TLSHandshake = time.Since(start)
+        conn = tlsConn
+    }
+    conn.SetDeadline(deadline)
+
+    // Upgrade the connection
+    key := make([]byte, 16)
+    rand.Read(key)
+    handshake := &http.Request{
+        Method: http.MethodGet,
+        URL: &url.URL{Scheme: "http", Host: u.Host, Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+        Header: make(http.Header),
+        Host: req.Host,
+    }
+    for k, values := range req.Header {
+        if !wsSkippedHeaders[k] {
+            handshake.Header[k] = values
+        }
+    }
+    handshake.Header.Set("Upgrade", "websocket")
+    handshake.Header.Set("Connection", "Upgrade")
+    handshake.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+    handshake.Header.Set("Sec-WebSocket-Version", "13")
+
+    start = time.Now()
+    r := bufio.NewReader(conn)
+    if err := handshake.Write(conn); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    resp, err := http.ReadResponse(r, handshake)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    resp.Body.Close()
+    damage.Handshake = time.Since(start)
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        conn.Close()
+        damage.StatusCode = resp.StatusCode
+        return nil, fmt.Errorf("websocket handshake: %s", resp.Status)
+    }
+    accept := sha1.Sum([]byte(handshake.Header.Get("Sec-WebSocket-Key") + wsGUID))
+    if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+        conn.Close()
+        return nil, errWSAccept
+    }
+    return &wsConn{Conn: conn, r: r}, nil
+}
+
+// A WebSocket connection of the client
+type wsConn struct {
+    net.Conn
+    r      *bufio.Reader
+    opened *Damage // Phases of the opening, until they are recorded to the damage of the first message
+}
+
+// Send a message, then read messages until the reply is matched.
+func (c *wsConn) send(opcode byte, msg []byte, match func(reply []byte) bool) ([]byte, error) {
+    if err := c.writeFrame(opcode, msg); err != nil {
+        return nil, err
+    }
+    for {
+        reply, err := c.readMessage()
+        if err != nil {
+            return nil, err
+        }
+        if match(reply) {
+            return reply, nil
+        }
+    }
+}
+
+// Write a frame, it's masked as it's from the client.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+    header := make([]byte, 2, 14)
+    header[0] = 0x80 | opcode
+    switch n := len(payload); {
+    case n < 126:
+        header[1] = byte(n)
+    case n <= 0xffff:
+        header[1] = 126
+        header = header[:4]
+        binary.BigEndian.PutUint16(header[2:], uint16(n))
+    default:
+        header[1] = 127
+        header = header[:10]
+        binary.BigEndian.PutUint64(header[2:], uint64(n))
+    }
+    header[1] |= 0x80
+    mask := make([]byte, 4)
+    rand.Read(mask)
+    header = append(header, mask...)
+    frame := make([]byte, len(header) + len(payload))
+    copy(frame, header)
+    for i, b := range payload {
+        frame[len(header) + i] = b ^ mask[i % 4]
+    }
+    _, err := c.Write(frame)
+    return err
+}
+
+// Read a message of data frames. Pings are answered, pongs are skipped, a close frame is returned as wsCloseError.
+func (c *wsConn) readMessage() ([]byte, error) {
+    var msg []byte
+    for {
+        fin, opcode, payload, err := c.readFrame()
+        if err != nil {
+            return nil, err
+        }
+        switch opcode {
+        case wsPing:
+            if err := c.writeFrame(wsPong, payload); err != nil {
+                return nil, err
+            }
+        case wsPong:
+        case wsClose:
+            closeErr := &wsCloseError{code: 1005}
+            if len(payload) >= 2 {
+                closeErr.code = int(binary.BigEndian.Uint16(payload))
+                closeErr.reason = string(payload[2:])
+            }
+            c.writeFrame(wsClose, payload)
+            return nil, closeErr
+        default:
+            if len(msg) + len(payload) > maxCheckedBody {
+                return nil, errWSMessageSize
+            }
+            msg = append(msg, payload...)
+            if fin {
+                return msg, nil
+            }
+        }
+    }
+}
+
+// Read a frame, the payload is unmasked if the server masks it.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+    header := make([]byte, 2)
+    if _, err = io.ReadFull(c.r, header); err != nil {
+        return
+    }
+    fin, opcode = header[0] & 0x80 != 0, header[0] & 0x0f
+    masked := header[1] & 0x80 != 0
+    n := uint64(header[1] & 0x7f)
+    switch n {
+    case 126:
+        ext := make([]byte, 2)
+        if _, err = io.ReadFull(c.r, ext); err != nil {
+            return
+        }
+        n = uint64(binary.BigEndian.Uint16(ext))
+    case 127:
+        ext := make([]byte, 8)
+        if _, err = io.ReadFull(c.r, ext); err != nil {
+            return
+        }
+        n = binary.BigEndian.Uint64(ext)
+    }
+    if n > maxCheckedBody {
+        err = errWSMessageSize
+        return
+    }
+    var mask []byte
+    if masked {
+        mask = make([]byte, 4)
+        if _, err = io.ReadFull(c.r, mask); err != nil {
+            return
+        }
+    }
+    payload = make([]byte, n)
+    if _, err = io.ReadFull(c.r, payload); err != nil {
+        return
+    }
+    if masked {
+        for i := range payload {
+            payload[i] ^= mask[i % 4]
+        }
+    }
+    return
+}
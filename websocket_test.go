@@ -0,0 +1,365 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// An unmasked frame as the server sends
+func serverFrame(fin bool, opcode byte, payload []byte) []byte {
+    header := []byte{opcode, 0}
+    if fin {
+        header[0] |= 0x80
+    }
+    switch n := len(payload); {
+    case n < 126:
+        header[1] = byte(n)
+    case n <= 0xffff:
+        header[1] = 126
+        header = binary.BigEndian.AppendUint16(header, uint16(n))
+    default:
+        header[1] = 127
+        header = binary.BigEndian.AppendUint64(header, uint64(n))
+    }
+    return append(header, payload...)
+}
+
+// A client connection and the server end of it
+func wsPipe() (*wsConn, net.Conn) {
+    client, server := net.Pipe()
+    return &wsConn{Conn: client, r: bufio.NewReader(client)}, server
+}
+
+func TestWSWriteFrame(t *testing.T) {
+    tests := []struct {
+        n      int
+        header int // Bytes of the header before the mask
+        length byte
+    }{
+        {0, 2, 0},
+        {125, 2, 125},
+        {126, 4, 126},
+        {0xffff, 4, 126},
+        {0x10000, 10, 127},
+    }
+    for _, tt := range tests {
+        c, server := wsPipe()
+        payload := bytes.Repeat([]byte("boom"), tt.n / 4 + 1)[:tt.n]
+        go func() {
+            c.writeFrame(wsBinary, payload)
+            c.Close()
+        }()
+        frame, _ := io.ReadAll(server)
+        if len(frame) != tt.header + 4 + tt.n {
+            t.Errorf("frame of %d bytes is %d bytes", tt.n, len(frame))
+            continue
+        }
+        if frame[0] != 0x80 | wsBinary {
+            t.Errorf("frame of %d bytes: first byte %#x, want FIN and binary", tt.n, frame[0])
+        }
+        // Frames of the client must be masked
+        if frame[1] & 0x80 == 0 || frame[1] & 0x7f != tt.length {
+            t.Errorf("frame of %d bytes: second byte %#x", tt.n, frame[1])
+        }
+        var n uint64
+        switch tt.length {
+        case 126:
+            n = uint64(binary.BigEndian.Uint16(frame[2:]))
+        case 127:
+            n = binary.BigEndian.Uint64(frame[2:])
+        default:
+            n = uint64(tt.length)
+        }
+        if n != uint64(tt.n) {
+            t.Errorf("frame of %d bytes: length %d", tt.n, n)
+        }
+        mask := frame[tt.header:tt.header + 4]
+        got := frame[tt.header + 4:]
+        for i := range got {
+            got[i] ^= mask[i % 4]
+        }
+        if !bytes.Equal(got, payload) {
+            t.Errorf("frame of %d bytes: unmasked payload differs", tt.n)
+        }
+        server.Close()
+    }
+}
+
+func TestWSReadFrame(t *testing.T) {
+    masked := []byte{0x81, 0x80 | 5, 1, 2, 3, 4}
+    for i, b := range []byte("hello") {
+        masked = append(masked, b ^ []byte{1, 2, 3, 4}[i % 4])
+    }
+    long := bytes.Repeat([]byte("x"), 0x10000)
+    tests := []struct {
+        name    string
+        frame   []byte
+        fin     bool
+        opcode  byte
+        payload []byte
+        err     error
+    }{
+        {"text", serverFrame(true, wsText, []byte("hi")), true, wsText, []byte("hi"), nil},
+        {"fragment", serverFrame(false, wsBinary, []byte{0, 1}), false, wsBinary, []byte{0, 1}, nil},
+        {"16-bit length", serverFrame(true, wsText, long[:300]), true, wsText, long[:300], nil},
+        {"64-bit length", serverFrame(true, wsText, long), true, wsText, long, nil},
+        {"masked by server", masked, true, wsText, []byte("hello"), nil},
+        {"too large", []byte{0x82, 127, 0, 0, 0, 0, 0xff, 0, 0, 0}, false, 0, nil, errWSMessageSize},
+        {"truncated", []byte{0x81, 5, 'h'}, false, 0, nil, io.ErrUnexpectedEOF},
+    }
+    for _, tt := range tests {
+        c, server := wsPipe()
+        go func() {
+            server.Write(tt.frame)
+            server.Close()
+        }()
+        fin, opcode, payload, err := c.readFrame()
+        if err != tt.err {
+            t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+        } else if err == nil && (fin != tt.fin || opcode != tt.opcode || !bytes.Equal(payload, tt.payload)) {
+            t.Errorf("%s: fin %v, opcode %#x, %d bytes", tt.name, fin, opcode, len(payload))
+        }
+        c.Close()
+    }
+}
+
+// Messages of fragments with control frames between them, pings are answered with pongs of the same payload
+func TestWSReadMessage(t *testing.T) {
+    c, server := wsPipe()
+    defer c.Close()
+    pongs := make(chan []byte, 1)
+    go func() {
+        server.Write(serverFrame(false, wsText, []byte("hel")))
+        server.Write(serverFrame(true, wsPing, []byte("are you there")))
+        // The pong of the client
+        peer := &wsConn{Conn: server, r: bufio.NewReader(server)}
+        _, opcode, payload, err := peer.readFrame()
+        if err == nil && opcode == wsPong {
+            pongs <- payload
+        }
+        close(pongs)
+        server.Write(serverFrame(true, wsPong, nil))
+        server.Write(serverFrame(false, wsContinuation, []byte("lo ")))
+        server.Write(serverFrame(true, wsContinuation, []byte("boom")))
+    }()
+    msg, err := c.readMessage()
+    if err != nil || string(msg) != "hello boom" {
+        t.Errorf("message = %q, %v, want hello boom", msg, err)
+    }
+    if pong := <-pongs; string(pong) != "are you there" {
+        t.Errorf("pong = %q", pong)
+    }
+}
+
+func TestWSReadMessageClose(t *testing.T) {
+    tests := []struct {
+        name    string
+        payload []byte
+        err     string
+    }{
+        {"code and reason", append([]byte{0x03, 0xe9}, "going away"...), "websocket closed by server: 1001 going away"},
+        {"code", []byte{0x03, 0xe8}, "websocket closed by server: 1000"},
+        {"no code", nil, "websocket closed by server: 1005"},
+    }
+    for _, tt := range tests {
+        c, server := wsPipe()
+        echoed := make(chan []byte, 1)
+        go func() {
+            server.Write(serverFrame(true, wsClose, tt.payload))
+            peer := &wsConn{Conn: server, r: bufio.NewReader(server)}
+            _, opcode, payload, err := peer.readFrame()
+            if err == nil && opcode == wsClose {
+                echoed <- payload
+            }
+            close(echoed)
+        }()
+        _, err := c.readMessage()
+        if _, ok := err.(*wsCloseError); !ok || err.Error() != tt.err {
+            t.Errorf("%s: error = %v, want %s", tt.name, err, tt.err)
+        }
+        // The close is answered
+        if payload, ok := <-echoed; !ok || !bytes.Equal(payload, tt.payload) {
+            t.Errorf("%s: close echoed %v, %q", tt.name, ok, payload)
+        }
+        c.Close()
+        server.Close()
+    }
+}
+
+// A WebSocket echo server counting the handshakes, /reject refuses them and /bad-accept answers a wrong key.
+func startWSServer(t *testing.T, handshakes *int64) *httptest.Server {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/reject" {
+            http.Error(w, "no", http.StatusForbidden)
+            return
+        }
+        if r.Header.Get("X-Token") != "secret" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+            http.Error(w, "bad handshake", http.StatusBadRequest)
+            return
+        }
+        atomic.AddInt64(handshakes, 1)
+        accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+        if r.URL.Path == "/bad-accept" {
+            accept[0]++
+        }
+        conn, rw, err := w.(http.Hijacker).Hijack()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+            "Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+        rw.Flush()
+        peer := &wsConn{Conn: conn, r: rw.Reader}
+        for {
+            _, opcode, payload, err := peer.readFrame()
+            if err != nil || opcode == wsClose {
+                return
+            }
+            conn.Write(serverFrame(true, opcode, payload))
+        }
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func wsTestEngine(t *testing.T, url string, warheads int, correlate string) (Engine, error) {
+    target := NewTarget(url)
+    target.AddHeader("X-Token", "secret")
+    targets, err := NewTargets([]*Target{target}, orderRoundRobin)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ct := NewDefaultCtrlCenter()
+    ct.Warheads = warheads
+    ct.Timeout = 2 * time.Second
+    return newWSEngine(ct, (&net.Dialer{}).DialContext, correlate, targets)
+}
+
+// All the connections are opened before the attack, their phases go to the first message on each
+func TestWSEngineOpensConnections(t *testing.T) {
+    var handshakes int64
+    server := startWSServer(t, &handshakes)
+    url := "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+    e, err := wsTestEngine(t, url, 5, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := atomic.LoadInt64(&handshakes); n != 5 {
+        t.Errorf("%d handshakes before the attack, want 5", n)
+    }
+    fresh := 0
+    for i := 0; i < 20; i++ {
+        req, _ := http.NewRequest(http.MethodGet, url, strings.NewReader("hello"))
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if damage.Error != "" || damage.ReceivedBytes != 5 {
+            t.Fatalf("hit %d: error %s, received %d", i, damage.Error, damage.ReceivedBytes)
+        }
+        if !damage.ConnReused {
+            fresh++
+            if damage.Handshake <= 0 || damage.Connect <= 0 {
+                t.Errorf("hit %d on a new connection: connect %v, handshake %v", i, damage.Connect, damage.Handshake)
+            }
+        }
+    }
+    if fresh != 5 || atomic.LoadInt64(&handshakes) != 5 {
+        t.Errorf("%d messages on new connections, %d handshakes, want 5", fresh, handshakes)
+    }
+}
+
+func TestWSEngineCorrelate(t *testing.T) {
+    var handshakes int64
+    server := startWSServer(t, &handshakes)
+    url := "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+    e, err := wsTestEngine(t, url, 1, "$.id")
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        body string
+        err  string
+    }{
+        {`{"id":7,"text":"hi"}`, ""},
+        {`{"text":"no id"}`, "no correlation id"},
+    }
+    for _, tt := range tests {
+        req, _ := http.NewRequest(http.MethodGet, url, strings.NewReader(tt.body))
+        damage := &Damage{}
+        e.Hit(req, damage)
+        if !strings.Contains(damage.Error, tt.err) || tt.err == "" && damage.Error != "" {
+            t.Errorf("%s: error = %q, want %q", tt.body, damage.Error, tt.err)
+        }
+    }
+}
+
+// Failed handshakes stop boom before the attack
+func TestWSEngineOpenErrors(t *testing.T) {
+    var handshakes int64
+    server := startWSServer(t, &handshakes)
+    ws := "ws" + strings.TrimPrefix(server.URL, "http")
+    closed, _ := net.Listen("tcp", "127.0.0.1:0")
+    closed.Close()
+    tests := []struct {
+        name string
+        url  string
+        err  string
+    }{
+        {"rejected", ws + "/reject", "3 of 3 websocket connections to " + ws + "/reject failed to open: websocket handshake: 403"},
+        {"bad accept", ws + "/bad-accept", errWSAccept.Error()},
+        {"refused", "ws://" + closed.Addr().String() + "/", "connection refused"},
+        {"bad scheme", "ftp://" + strings.TrimPrefix(server.URL, "http://") + "/", errWSURL.Error()},
+    }
+    for _, tt := range tests {
+        _, err := wsTestEngine(t, tt.url, 3, "")
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+            t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.err)
+        }
+    }
+}
+
+// Messages of several targets go over the same connections, so the targets must have the same url
+func TestWSEngineTargets(t *testing.T) {
+    var handshakes int64
+    server := startWSServer(t, &handshakes)
+    ws := "ws" + strings.TrimPrefix(server.URL, "http")
+    tests := []struct {
+        name       string
+        urls       []string
+        err        error
+        handshakes int64
+    }{
+        {"same url", []string{ws + "/chat", ws + "/chat"}, nil, 2},
+        {"other url", []string{ws + "/chat", ws + "/chat", ws + "/news"}, errWSTargets, 0},
+    }
+    for _, tt := range tests {
+        atomic.StoreInt64(&handshakes, 0)
+        var list []*Target
+        for _, url := range tt.urls {
+            target := NewTarget(url)
+            target.AddHeader("X-Token", "secret")
+            list = append(list, target)
+        }
+        targets, err := NewTargets(list, orderRoundRobin)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ct := NewDefaultCtrlCenter()
+        ct.Warheads = 2
+        _, err = newWSEngine(ct, (&net.Dialer{}).DialContext, "", targets)
+        if err != tt.err || atomic.LoadInt64(&handshakes) != tt.handshakes {
+            t.Errorf("%s: error %v, %d handshakes, want %v, %d", tt.name, err, handshakes, tt.err, tt.handshakes)
+        }
+    }
+}